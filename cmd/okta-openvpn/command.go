@@ -7,6 +7,7 @@ import (
 
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/commands/auth"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/commands/clientconnect"
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/commands/version"
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
 	"github.com/spf13/cobra"
@@ -56,6 +57,7 @@ func NewRootCommand() *RootCommand {
 
 	// add commands
 	cmd.AddCommand(&auth.NewCommand().Command)
+	cmd.AddCommand(&clientconnect.NewCommand().Command)
 	cmd.AddCommand(&version.NewCommand().Command)
 
 	return cmd
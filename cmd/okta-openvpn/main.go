@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	golog "log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/Masterminds/semver"
@@ -63,9 +66,11 @@ func main() {
 	}
 	app.SemanticVersion = semVer
 
-	// execute the command
+	// execute the command, aborting any work in progress if we are interrupted or terminated (eg: by OpenVPN)
 	var exitCode int
-	err = NewRootCommand().Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = NewRootCommand().ExecuteContext(ctx)
+	stop()
 	if e, ok := err.(tberrors.ExtendedError); ok {
 		// the extended error message should already have been logged during execution
 		exitCode = e.Code()
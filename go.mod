@@ -11,6 +11,8 @@ require (
 	github.com/spf13/viper v1.10.0
 	go.innotegrity.dev/toolbox v0.1.0
 	go.innotegrity.dev/zerolog v1.30.0
+	golang.org/x/net v0.0.0-20210813160813-60bc85c4be6d
+	golang.org/x/text v0.3.7
 	gopkg.in/resty.v1 v1.12.0
 )
 
@@ -29,9 +31,7 @@ require (
 	github.com/spf13/cast v1.4.1 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/subosito/gotenv v1.2.0 // indirect
-	golang.org/x/net v0.0.0-20210813160813-60bc85c4be6d // indirect
 	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
-	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/ini.v1 v1.66.2 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -19,13 +19,57 @@ func init() {
 	Config = &config{}
 
 	// initialize default settings
+	viper.SetDefault("auth.allowed_groups", []string{})
 	viper.SetDefault("auth.api_key_file", "")
+	viper.SetDefault("auth.api_mode", DefaultAPIMode)
+	viper.SetDefault("auth.client_context.device_fingerprint", false)
+	viper.SetDefault("auth.client_context.enabled", true)
+	viper.SetDefault("auth.client_context.user_agent", DefaultUserAgent)
+	viper.SetDefault("auth.denied_groups", []string{})
+	viper.SetDefault("auth.factor_provider_priority", []string{})
 	viper.SetDefault("auth.geoip_db_path", "")
 	viper.SetDefault("auth.geoip_locale", DefaultGeoIPLocale)
+	viper.SetDefault("auth.http.no_proxy", []string{})
+	viper.SetDefault("auth.http.proxy_credentials_file", "")
+	viper.SetDefault("auth.http.proxy_url", "")
 	viper.SetDefault("auth.interactive", false)
 	viper.SetDefault("auth.mfa_methods", []string{})
 	viper.SetDefault("auth.mfa_timeout", DefaultMFATimeout)
+	viper.SetDefault("auth.oidc.authorization_server", DefaultOIDCAuthorizationServer)
+	viper.SetDefault("auth.oidc.client_id", "")
+	viper.SetDefault("auth.oidc.client_secret_file", "")
+	viper.SetDefault("auth.oidc.redirect_uri", "")
+	viper.SetDefault("auth.oidc.scopes", DefaultOIDCScopes)
 	viper.SetDefault("auth.org_name", "")
+	viper.SetDefault("auth.org_url", "")
+	viper.SetDefault("auth.passcode_fallback", false)
+	viper.SetDefault("auth.remember_device", false)
+	viper.SetDefault("auth.required_app_id", "")
+	viper.SetDefault("auth.retry.base_backoff", DefaultRetryBaseBackoff)
+	viper.SetDefault("auth.retry.jitter", DefaultRetryJitter)
+	viper.SetDefault("auth.retry.max_attempts", DefaultRetryMaxAttempts)
+	viper.SetDefault("auth.retry.max_backoff", DefaultRetryMaxBackoff)
+	viper.SetDefault("auth.retry.retryable_status_codes", DefaultRetryableStatusCodes)
+	viper.SetDefault("auth.state_dir", DefaultStateDir)
+	viper.SetDefault("auth.tls.ca_bundle_file", "")
+	viper.SetDefault("auth.tls.client_cert_file", "")
+	viper.SetDefault("auth.tls.client_key_file", "")
+	viper.SetDefault("auth.tls.min_version", DefaultTLSVersion)
+	viper.SetDefault("auth.tls.spki_pins", []string{})
+	viper.SetDefault("auth.username_rules.default_domain", "")
+	viper.SetDefault("auth.username_rules.lowercase", false)
+	viper.SetDefault("auth.username_rules.rewrites", []interface{}{})
+	viper.SetDefault("auth.username_rules.strip_domain_prefix", false)
+	viper.SetDefault("auth.username_rules.unicode_normalize", false)
+
+	viper.SetDefault("client_config.default.dhcp_options", []string{})
+	viper.SetDefault("client_config.default.iroutes", []string{})
+	viper.SetDefault("client_config.default.routes", []string{})
+	viper.SetDefault("client_config.groups", []interface{}{})
+	viper.SetDefault("client_config.static_address.ipv4_attribute", "")
+	viper.SetDefault("client_config.static_address.ipv4_pool", "")
+	viper.SetDefault("client_config.static_address.ipv6_attribute", "")
+	viper.SetDefault("client_config.static_address.ipv6_pool", "")
 
 	viper.SetDefault("global.log_level", DefaultLogLevel)
 	viper.SetDefault("global.enable_json_logging", false)
@@ -38,6 +82,9 @@ type config struct {
 	// Auth stores auth command configuration options
 	Auth AuthOptions `mapstructure:"auth"`
 
+	// ClientConfig stores client-connect command configuration options
+	ClientConfig ClientConfigOptions `mapstructure:"client_config"`
+
 	// Global stores the global configuration options
 	Global GlobalOptions `mapstructure:"global"`
 
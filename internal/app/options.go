@@ -1,12 +1,18 @@
 package app
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	goerrors "errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -18,41 +24,106 @@ import (
 
 // Default configuration settings.
 const (
+	DefaultAPIMode     = APIModeClassic
 	DefaultConfigFile  = "config"
 	DefaultGeoIPLocale = "en"
 	DefaultLogLevel    = "info"
 	DefaultMFATimeout  = "30s"
+	DefaultOrgURL      = "https://%s.okta.com"
+	DefaultStateDir    = "/opt/okta-openvpn-auth-plugin/var"
+	DefaultTLSVersion  = "1.2"
+	DefaultUserAgent   = "OpenVPN/{version} ({platform}; {gui_version})"
+
+	DefaultOIDCAuthorizationServer = "default"
+
+	DefaultRetryBaseBackoff = "500ms"
+	DefaultRetryJitter      = 0.2
+	DefaultRetryMaxAttempts = 3
+	DefaultRetryMaxBackoff  = "5s"
 
 	MinMFATimeout = 15
 )
 
+// DefaultOIDCScopes holds the OAuth 2.0 scopes requested by default.
+var DefaultOIDCScopes = []string{"openid", "profile"}
+
+// DefaultRetryableStatusCodes holds the HTTP status codes which are retried by default.
+var DefaultRetryableStatusCodes = []int{502, 503, 504}
+
+// RedactedValue replaces secrets in settings which are logged.
+const RedactedValue = "[REDACTED]"
+
+// Supported Okta API modes
+const (
+	APIModeClassic = "classic"
+	APIModeIDX     = "idx"
+	APIModeOIDC    = "oidc"
+)
+
+var apiModeStrings = []string{APIModeClassic, APIModeIDX, APIModeOIDC}
+
 // Supported MFA methods
 const (
-	MFANone = 0
-	MFATOTP = 1
-	MFAPush = 2
+	MFANone  = 0
+	MFATOTP  = 1
+	MFAPush  = 2
+	MFASMS   = 4
+	MFAEmail = 8
+	MFACall  = 16
+
+	MFAYubiKey  = 32
+	MFARSA      = 64
+	MFASymantec = 128
 )
 
 var mfaMethodStrings = map[string]uint8{
-	"none": MFANone,
-	"totp": MFATOTP,
-	"push": MFAPush,
+	"none":  MFANone,
+	"totp":  MFATOTP,
+	"push":  MFAPush,
+	"sms":   MFASMS,
+	"email": MFAEmail,
+	"call":  MFACall,
+
+	"yubikey":  MFAYubiKey,
+	"rsa":      MFARSA,
+	"symantec": MFASymantec,
 }
 
 // AuthOptions holds the options for the auth command.
 type AuthOptions struct {
+	// AllowedGroups holds the names or IDs of the groups a user must belong to (any of them) in order to connect.
+	//
+	// If this is empty, group membership is not required.
+	AllowedGroups []string `mapstructure:"allowed_groups"`
+
+	// ClientContext holds the settings for passing details about the OpenVPN client to Okta.
+	ClientContext ClientContextOptions `mapstructure:"client_context"`
+
 	// APIKeyFile holds the path to the Okta API key.
 	APIKeyFile string `mapstructure:"api_key_file"`
 
 	// APIKey holds the actual API key read from the API key file.
 	APIKey string
 
+	// APIMode holds the Okta API used to authenticate users (classic, idx or oidc).
+	APIMode string `mapstructure:"api_mode"`
+
+	// DeniedGroups holds the names or IDs of the groups whose members are not allowed to connect.
+	DeniedGroups []string `mapstructure:"denied_groups"`
+
+	// FactorProviderPriority holds the order in which factor providers are tried when a user is enrolled in more than
+	// one factor which accepts the same passcode (eg: OKTA before GOOGLE).
+	FactorProviderPriority []string `mapstructure:"factor_provider_priority"`
+
 	// GeoIPDBPath holds the path to the GeoIP data files.
 	GeoIPDBPath string `mapstructure:"geoip_db_path"`
 
 	// GeoIPLocale holds the locale to use for retrieving GeoIP data.
 	GeoIPLocale string `mapstructure:"geoip_locale"`
 
+	// HTTP holds the settings for the HTTP client used to make Okta API requests.
+	HTTP HTTPOptions `mapstructure:"http"`
+
 	// Interactive determines whether or not to perform an interactive authentication.
 	Interactive bool `mapstructure:"interactive"`
 
@@ -62,15 +133,59 @@ type AuthOptions struct {
 	// MFATimeout holds the length of time to wait for a user to respond to an MFA request before timing out.
 	MFATimeout time.Duration
 
+	// OIDC holds the settings for the Okta OIDC application used by the idx and oidc API modes.
+	OIDC OIDCOptions `mapstructure:"oidc"`
+
 	// OrgName holds the name of the Okta organization.
 	OrgName string `mapstructure:"org_name"`
 
+	// OrgURL holds the base URL of the Okta organization (eg: https://login.example.com).
+	//
+	// If this is empty, it is built from OrgName using the standard okta.com domain.
+	OrgURL string `mapstructure:"org_url"`
+
+	// PasscodeFallback determines whether or not the passcode is verified against the next matching factor when a
+	// factor rejects it as invalid.
+	PasscodeFallback bool `mapstructure:"passcode_fallback"`
+
+	// RememberDevice determines whether or not Okta is asked to remember the user's device once MFA succeeds when the
+	// sign-on policy allows it.
+	RememberDevice bool `mapstructure:"remember_device"`
+
+	// RequiredAppID holds the ID of the Okta application users must be assigned to in order to connect.
+	//
+	// If this is empty, application assignment is not checked.
+	RequiredAppID string `mapstructure:"required_app_id"`
+
 	// RawMFAMethods holds the list of unvalidated MFA methods.
 	RawMFAMethods []string `mapstructure:"mfa_methods"`
 
 	// RawMFATimeout holds the unparsed duration of how long to wait for a user to respond to an MFA request
 	// before timing out.
 	RawMFATimeout string `mapstructure:"mfa_timeout"`
+
+	// Retry holds the settings for retrying Okta API requests which fail due to transient errors.
+	Retry RetryOptions `mapstructure:"retry"`
+
+	// StateDir holds the path to the directory in which pending MFA transactions are stored.
+	StateDir string `mapstructure:"state_dir"`
+
+	// TLS holds the settings for TLS connections made to Okta.
+	TLS TLSOptions `mapstructure:"tls"`
+
+	// UsernameRules holds the rules used to normalize usernames before they are sent to Okta.
+	UsernameRules UsernameRulesOptions `mapstructure:"username_rules"`
+}
+
+// Redacted returns a copy of the options with the API key and OIDC client secret masked so that it can be logged.
+func (o AuthOptions) Redacted() AuthOptions {
+	if o.APIKey != "" {
+		o.APIKey = RedactedValue
+	}
+	if o.OIDC.ClientSecret != "" {
+		o.OIDC.ClientSecret = RedactedValue
+	}
+	return o
 }
 
 // Validate checks and saves any configuration settings from viper and ensures that all values are sane.
@@ -79,9 +194,51 @@ type AuthOptions struct {
 // ConfigValidateFailure
 func (o *AuthOptions) Validate() error {
 	// validate organization
-	if err := requireSetting(o.OrgName, "auth.org_name"); err != nil {
+	if o.OrgURL == "" {
+		if err := requireSetting(o.OrgName, "auth.org_name"); err != nil {
+			return err
+		}
+		o.OrgURL = fmt.Sprintf(DefaultOrgURL, o.OrgName)
+	} else {
+		orgURL, err := parseOrgURL(o.OrgURL)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: "auth.org_url",
+				Value:   o.OrgURL,
+				Err:     err,
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		o.OrgURL = orgURL
+	}
+
+	// validate API mode
+	o.APIMode = strings.ToLower(strings.TrimSpace(o.APIMode))
+	if !isAPIMode(o.APIMode) {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.api_mode",
+			Value:   o.APIMode,
+			Err:     fmt.Errorf("value must be one of: %s", strings.Join(apiModeStrings, ", ")),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
+	// validate OIDC application
+	if err := o.OIDC.Validate(); err != nil {
 		return err
 	}
+	if o.APIMode == APIModeIDX || o.APIMode == APIModeOIDC {
+		if err := requireSetting(o.OIDC.ClientID, "auth.oidc.client_id"); err != nil {
+			return err
+		}
+	}
+	if o.APIMode == APIModeIDX {
+		if err := requireSetting(o.OIDC.RedirectURI, "auth.oidc.redirect_uri"); err != nil {
+			return err
+		}
+	}
 
 	// read the API key, if present
 	if o.APIKeyFile != "" {
@@ -121,6 +278,19 @@ func (o *AuthOptions) Validate() error {
 		o.APIKey = strings.TrimSpace(string(decodedKey))
 	}
 
+	// authorization requires an API key to look up the user's groups and application assignments
+	o.RequiredAppID = strings.TrimSpace(o.RequiredAppID)
+	if (len(o.AllowedGroups) > 0 || len(o.DeniedGroups) > 0 || o.RequiredAppID != "") && o.APIKey == "" {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.api_key_file",
+			Value:   o.APIKeyFile,
+			Err: goerrors.New("an API key is required when auth.allowed_groups, auth.denied_groups or " +
+				"auth.required_app_id is set"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
 	// test opening the GeoIP database
 	if o.GeoIPDBPath != "" {
 		setting := "auth.geoip_db_path"
@@ -175,6 +345,27 @@ func (o *AuthOptions) Validate() error {
 		o.MFAMethods = o.MFAMethods | m
 	}
 
+	// validate factor provider priority
+	for i, provider := range o.FactorProviderPriority {
+		provider = strings.ToUpper(strings.TrimSpace(provider))
+		if provider == "" {
+			e := &errors.ConfigValidateFailure{
+				Setting: "auth.factor_provider_priority",
+				Value:   o.FactorProviderPriority,
+				Err:     goerrors.New("factor provider cannot be empty"),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		o.FactorProviderPriority[i] = provider
+	}
+
+	if o.APIMode == APIModeOIDC && o.MFAMethods != MFANone {
+		log.Warn().Str("setting", "auth.mfa_methods").Interface("value", o.RawMFAMethods).
+			Msg("MFA methods are not supported by the 'oidc' API mode and will be ignored")
+		o.MFAMethods = MFANone
+	}
+
 	// validate MFA timeout
 	setting := "auth.mfa_timeout"
 	duration, err := time.ParseDuration(o.RawMFATimeout)
@@ -196,6 +387,244 @@ func (o *AuthOptions) Validate() error {
 		o.MFATimeout = duration
 	}
 
+	// validate retry policy
+	if err := o.Retry.Validate(); err != nil {
+		return err
+	}
+
+	// validate client context
+	if err := o.ClientContext.Validate(); err != nil {
+		return err
+	}
+
+	// validate HTTP client settings
+	if err := o.HTTP.Validate(); err != nil {
+		return err
+	}
+	if err := o.TLS.Validate(); err != nil {
+		return err
+	}
+
+	// validate state directory
+	if err := requireSetting(o.StateDir, "auth.state_dir"); err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(o.StateDir)
+	if err != nil {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.state_dir",
+			Value:   o.StateDir,
+			Err:     err,
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+	o.StateDir = absPath
+
+	// validate username rules
+	if err := o.UsernameRules.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ClientContextOptions holds the settings for passing details about the OpenVPN client to Okta.
+//
+// Okta only uses these details when requests are made using an API key.
+type ClientContextOptions struct {
+	// DeviceFingerprint determines whether or not the client's device token is sent in the X-Device-Fingerprint
+	// header.
+	DeviceFingerprint bool `mapstructure:"device_fingerprint"`
+
+	// Enabled determines whether or not the client's IP address and user agent are sent to Okta.
+	Enabled bool `mapstructure:"enabled"`
+
+	// UserAgent holds the template for the User-Agent header sent to Okta.
+	//
+	// The {gui_version}, {platform} and {version} placeholders are replaced by the IV_GUI_VER, IV_PLAT and IV_VER
+	// values sent by the OpenVPN client.
+	UserAgent string `mapstructure:"user_agent"`
+}
+
+// Validate checks and saves any configuration settings from viper and ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *ClientContextOptions) Validate() error {
+	o.UserAgent = strings.TrimSpace(o.UserAgent)
+	if strings.ContainsAny(o.UserAgent, "\r\n") {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.client_context.user_agent",
+			Value:   o.UserAgent,
+			Err:     goerrors.New("value cannot contain line breaks"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+	return nil
+}
+
+// ClientConfigEntry holds the OpenVPN client configuration pushed to a user.
+type ClientConfigEntry struct {
+	// DHCPOptions holds the DHCP options pushed to the client (eg: DNS 10.0.0.2).
+	DHCPOptions []string `mapstructure:"dhcp_options"`
+
+	// IRoutes holds the internal routes to networks behind the client (eg: 192.168.1.0 255.255.255.0).
+	IRoutes []string `mapstructure:"iroutes"`
+
+	// Routes holds the routes pushed to the client (eg: 10.0.0.0 255.255.0.0).
+	Routes []string `mapstructure:"routes"`
+}
+
+// validate ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *ClientConfigEntry) validate(setting string) error {
+	values := []struct {
+		name string
+		list []string
+	}{
+		{"dhcp_options", o.DHCPOptions},
+		{"iroutes", o.IRoutes},
+		{"routes", o.Routes},
+	}
+	for _, v := range values {
+		name := v.name
+		for _, value := range v.list {
+			var err error
+			fields := strings.Fields(value)
+			switch {
+			case len(fields) == 0:
+				err = goerrors.New("value cannot be empty")
+			case strings.ContainsAny(value, "\"\r\n\\"):
+				err = goerrors.New("value cannot contain quotes, backslashes or line breaks")
+			case name != "dhcp_options" && net.ParseIP(fields[0]) == nil:
+				err = fmt.Errorf("'%s' is not a valid network address", fields[0])
+			case name == "iroutes" && len(fields) > 2:
+				err = goerrors.New("value must be a network address optionally followed by a netmask")
+			}
+			if err != nil {
+				e := &errors.ConfigValidateFailure{
+					Setting: fmt.Sprintf("%s.%s", setting, name),
+					Value:   value,
+					Err:     err,
+				}
+				log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// ClientConfigGroup holds the OpenVPN client configuration pushed to members of an Okta group.
+type ClientConfigGroup struct {
+	ClientConfigEntry `mapstructure:",squash"`
+
+	// Group holds the name or ID of the Okta group.
+	Group string `mapstructure:"group"`
+}
+
+// ClientConfigOptions holds the options for the client-connect command.
+type ClientConfigOptions struct {
+	// Default holds the configuration pushed to every user.
+	Default ClientConfigEntry `mapstructure:"default"`
+
+	// Groups holds the configuration pushed to members of each group.
+	//
+	// When a user is a member of several groups, the configuration for each group is applied in the order in which
+	// the groups are listed, after the default configuration.
+	Groups []ClientConfigGroup `mapstructure:"groups"`
+
+	// StaticAddress holds the options for assigning static VPN addresses from Okta profile attributes.
+	StaticAddress StaticAddressOptions `mapstructure:"static_address"`
+}
+
+// Validate checks and saves any configuration settings from viper and ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *ClientConfigOptions) Validate() error {
+	if err := o.Default.validate("client_config.default"); err != nil {
+		return err
+	}
+	for i := range o.Groups {
+		g := &o.Groups[i]
+		g.Group = strings.TrimSpace(g.Group)
+		if err := requireSetting(g.Group, fmt.Sprintf("client_config.groups[%d].group", i)); err != nil {
+			return err
+		}
+		if err := g.validate(fmt.Sprintf("client_config.groups[%d]", i)); err != nil {
+			return err
+		}
+	}
+	return o.StaticAddress.validate()
+}
+
+// StaticAddressOptions holds the options for assigning static VPN addresses from Okta profile attributes.
+type StaticAddressOptions struct {
+	// IPv4Attribute holds the name of the Okta profile attribute containing the user's IPv4 address.
+	IPv4Attribute string `mapstructure:"ipv4_attribute"`
+
+	// IPv4Network is the parsed IPv4 address pool.
+	IPv4Network *net.IPNet
+
+	// IPv4Pool holds the OpenVPN server's IPv4 address pool in CIDR notation (eg: 10.8.0.0/24).
+	IPv4Pool string `mapstructure:"ipv4_pool"`
+
+	// IPv6Attribute holds the name of the Okta profile attribute containing the user's IPv6 address.
+	IPv6Attribute string `mapstructure:"ipv6_attribute"`
+
+	// IPv6Network is the parsed IPv6 address pool.
+	IPv6Network *net.IPNet
+
+	// IPv6Pool holds the OpenVPN server's IPv6 address pool in CIDR notation (eg: fd00:8::/64).
+	IPv6Pool string `mapstructure:"ipv6_pool"`
+}
+
+// validate ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *StaticAddressOptions) validate() error {
+	o.IPv4Attribute = strings.TrimSpace(o.IPv4Attribute)
+	o.IPv6Attribute = strings.TrimSpace(o.IPv6Attribute)
+	pools := []struct {
+		attribute string
+		network   **net.IPNet
+		pool      string
+		setting   string
+		ipv4      bool
+	}{
+		{o.IPv4Attribute, &o.IPv4Network, strings.TrimSpace(o.IPv4Pool), "client_config.static_address.ipv4_pool",
+			true},
+		{o.IPv6Attribute, &o.IPv6Network, strings.TrimSpace(o.IPv6Pool), "client_config.static_address.ipv6_pool",
+			false},
+	}
+	for _, p := range pools {
+		if p.attribute == "" {
+			continue
+		}
+		if err := requireSetting(p.pool, p.setting); err != nil {
+			return err
+		}
+		_, network, err := net.ParseCIDR(p.pool)
+		if err == nil && (network.IP.To4() != nil) != p.ipv4 {
+			err = goerrors.New("network is not of the expected address family")
+		}
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: p.setting,
+				Value:   p.pool,
+				Err:     err,
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		*p.network = network
+	}
 	return nil
 }
 
@@ -235,6 +664,465 @@ func (o *GlobalOptions) Validate() error {
 	return nil
 }
 
+// HTTPOptions holds the settings for the HTTP client used to make Okta API requests.
+type HTTPOptions struct {
+	// NoProxy holds the hosts, domains, IP addresses and networks which are reached without using the proxy
+	// (eg: .example.com, 10.0.0.0/8).
+	NoProxy []string `mapstructure:"no_proxy"`
+
+	// Proxy is the parsed proxy URL including any proxy credentials.
+	Proxy *url.URL
+
+	// ProxyCredentialsFile holds the path to the file containing the proxy credentials as 'username:password'.
+	ProxyCredentialsFile string `mapstructure:"proxy_credentials_file"`
+
+	// ProxyURL holds the URL of the HTTP proxy used to reach Okta (eg: http://proxy.example.com:3128).
+	//
+	// If this is empty, the proxy is taken from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+	ProxyURL string `mapstructure:"proxy_url"`
+}
+
+// Validate checks and saves any configuration settings from viper and ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *HTTPOptions) Validate() error {
+	for i, host := range o.NoProxy {
+		o.NoProxy[i] = strings.TrimSpace(host)
+	}
+
+	o.Proxy = nil
+	o.ProxyURL = strings.TrimSpace(o.ProxyURL)
+	if o.ProxyURL == "" {
+		if o.ProxyCredentialsFile != "" {
+			log.Warn().Str("setting", "auth.http.proxy_credentials_file").Interface("value", o.ProxyCredentialsFile).
+				Msg("proxy credentials are ignored because no proxy URL is set")
+		}
+		return nil
+	}
+	proxyURL, err := url.Parse(o.ProxyURL)
+	if err == nil {
+		switch {
+		case proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5":
+			err = goerrors.New("scheme must be one of: http, https, socks5")
+		case proxyURL.Host == "":
+			err = goerrors.New("proxy host cannot be empty")
+		case proxyURL.User != nil:
+			err = goerrors.New("credentials must be supplied using auth.http.proxy_credentials_file")
+		}
+	}
+	if err != nil {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.http.proxy_url",
+			Value:   o.ProxyURL,
+			Err:     err,
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
+	// read the proxy credentials, if present
+	if o.ProxyCredentialsFile != "" {
+		setting := "auth.http.proxy_credentials_file"
+		absPath, err := filepath.Abs(o.ProxyCredentialsFile)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   o.ProxyCredentialsFile,
+				Err:     err,
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+
+		credentials, err := ioutil.ReadFile(absPath)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   absPath,
+				Err:     fmt.Errorf("error reading the proxy credentials file: %s", err.Error()),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		parts := strings.SplitN(strings.TrimSpace(string(credentials)), ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   absPath,
+				Err:     goerrors.New("proxy credentials must be in the form 'username:password'"),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		proxyURL.User = url.UserPassword(parts[0], parts[1])
+	}
+	o.Proxy = proxyURL
+	return nil
+}
+
+// OIDCOptions holds the settings for the Okta OIDC application used to authenticate users.
+type OIDCOptions struct {
+	// AuthorizationServer holds the ID of the Okta authorization server (eg: default).
+	//
+	// If this is empty, the org authorization server is used.
+	AuthorizationServer string `mapstructure:"authorization_server"`
+
+	// ClientID holds the client ID of the OIDC application.
+	ClientID string `mapstructure:"client_id"`
+
+	// ClientSecret holds the actual client secret read from the client secret file.
+	ClientSecret string
+
+	// ClientSecretFile holds the path to the client secret of the OIDC application.
+	ClientSecretFile string `mapstructure:"client_secret_file"`
+
+	// RedirectURI holds the sign-in redirect URI registered for the OIDC application.
+	RedirectURI string `mapstructure:"redirect_uri"`
+
+	// Scopes holds the OAuth 2.0 scopes to request.
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// Validate checks and saves any configuration settings from viper and ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *OIDCOptions) Validate() error {
+	// validate scopes
+	if len(o.Scopes) == 0 {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.oidc.scopes",
+			Value:   o.Scopes,
+			Err:     goerrors.New("at least one scope must be specified"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
+	// read the client secret, if present
+	if o.ClientSecretFile != "" {
+		setting := "auth.oidc.client_secret_file"
+		absPath, err := filepath.Abs(o.ClientSecretFile)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   o.ClientSecretFile,
+				Err:     err,
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+
+		secret, err := ioutil.ReadFile(absPath)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   absPath,
+				Err:     fmt.Errorf("error reading the client secret file: %s", err.Error()),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		o.ClientSecret = strings.TrimSpace(string(secret))
+	}
+
+	return nil
+}
+
+// RetryOptions holds the settings for retrying Okta API requests which fail due to transient errors.
+type RetryOptions struct {
+	// BaseBackoff holds the length of time to wait before the first retry.
+	BaseBackoff time.Duration
+
+	// Jitter holds the fraction (0-1) of each wait between attempts which is randomized.
+	Jitter float64 `mapstructure:"jitter"`
+
+	// MaxAttempts holds the maximum number of times a request is attempted, including the first attempt.
+	MaxAttempts int `mapstructure:"max_attempts"`
+
+	// MaxBackoff holds the maximum length of time to wait between attempts.
+	MaxBackoff time.Duration
+
+	// RawBaseBackoff holds the unparsed length of time to wait before the first retry.
+	RawBaseBackoff string `mapstructure:"base_backoff"`
+
+	// RawMaxBackoff holds the unparsed maximum length of time to wait between attempts.
+	RawMaxBackoff string `mapstructure:"max_backoff"`
+
+	// RetryableStatusCodes holds the HTTP status codes which are considered transient failures.
+	RetryableStatusCodes []int `mapstructure:"retryable_status_codes"`
+}
+
+// Validate checks and saves any configuration settings from viper and ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *RetryOptions) Validate() error {
+	// validate maximum attempts
+	if o.MaxAttempts < 1 {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.retry.max_attempts",
+			Value:   o.MaxAttempts,
+			Err:     goerrors.New("value must be at least 1"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
+	// validate backoff durations
+	duration, err := parseDuration(o.RawBaseBackoff, "auth.retry.base_backoff")
+	if err != nil {
+		return err
+	}
+	o.BaseBackoff = duration
+	duration, err = parseDuration(o.RawMaxBackoff, "auth.retry.max_backoff")
+	if err != nil {
+		return err
+	}
+	o.MaxBackoff = duration
+	if o.MaxBackoff < o.BaseBackoff {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.retry.max_backoff",
+			Value:   o.RawMaxBackoff,
+			Err:     goerrors.New("value cannot be less than auth.retry.base_backoff"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
+	// validate jitter
+	if o.Jitter < 0 || o.Jitter > 1 {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.retry.jitter",
+			Value:   o.Jitter,
+			Err:     goerrors.New("value must be between 0 and 1"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
+	// validate status codes
+	for _, code := range o.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			e := &errors.ConfigValidateFailure{
+				Setting: "auth.retry.retryable_status_codes",
+				Value:   code,
+				Err:     goerrors.New("value is not a valid HTTP status code"),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+	}
+
+	return nil
+}
+
+// TLSOptions holds the settings for TLS connections made to Okta.
+type TLSOptions struct {
+	// CABundleFile holds the path to a PEM file containing CA certificates trusted in addition to the system's.
+	CABundleFile string `mapstructure:"ca_bundle_file"`
+
+	// ClientCertificates holds the loaded client certificate, if any.
+	ClientCertificates []tls.Certificate
+
+	// ClientCertFile holds the path to the PEM client certificate presented when a server (eg: a proxy) requests one.
+	ClientCertFile string `mapstructure:"client_cert_file"`
+
+	// ClientKeyFile holds the path to the PEM private key for the client certificate.
+	ClientKeyFile string `mapstructure:"client_key_file"`
+
+	// MinVersion holds the minimum TLS version.
+	MinVersion uint16
+
+	// PinDigests holds the decoded SPKI SHA-256 pins.
+	PinDigests [][]byte
+
+	// RawMinVersion holds the unparsed minimum TLS version (eg: 1.2).
+	RawMinVersion string `mapstructure:"min_version"`
+
+	// RootCAs holds the system CA certificates along with those from CABundleFile.
+	//
+	// If this is nil, only the system CA certificates are trusted.
+	RootCAs *x509.CertPool
+
+	// SPKIPins holds the base64-encoded SHA-256 digests of the subject public keys, at least one of which must be
+	// present in the verified certificate chain of every connection, including the connection to an https:// proxy.
+	//
+	// If this is empty, certificates are not pinned.
+	SPKIPins []string `mapstructure:"spki_pins"`
+}
+
+// tlsVersions maps each supported minimum TLS version to its identifier.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Validate checks and saves any configuration settings from viper and ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *TLSOptions) Validate() error {
+	// validate minimum version
+	version, ok := tlsVersions[strings.TrimSpace(o.RawMinVersion)]
+	if !ok {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.tls.min_version",
+			Value:   o.RawMinVersion,
+			Err:     goerrors.New("value must be one of: 1.0, 1.1, 1.2, 1.3"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+	o.MinVersion = version
+
+	// load the CA bundle, if present
+	o.RootCAs = nil
+	if o.CABundleFile != "" {
+		setting := "auth.tls.ca_bundle_file"
+		bundle, err := ioutil.ReadFile(o.CABundleFile)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   o.CABundleFile,
+				Err:     fmt.Errorf("error reading the CA bundle: %s", err.Error()),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to load system CA certificates; only the CA bundle will be trusted")
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   o.CABundleFile,
+				Err:     goerrors.New("no PEM certificates were found in the CA bundle"),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		o.RootCAs = pool
+	}
+
+	// load the client certificate, if present
+	o.ClientCertificates = nil
+	if o.ClientCertFile != "" || o.ClientKeyFile != "" {
+		if err := requireSetting(o.ClientCertFile, "auth.tls.client_cert_file"); err != nil {
+			return err
+		}
+		if err := requireSetting(o.ClientKeyFile, "auth.tls.client_key_file"); err != nil {
+			return err
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCertFile, o.ClientKeyFile)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: "auth.tls.client_cert_file",
+				Value:   o.ClientCertFile,
+				Err:     fmt.Errorf("error loading the client certificate: %s", err.Error()),
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		o.ClientCertificates = []tls.Certificate{cert}
+	}
+
+	// decode the pins
+	o.PinDigests = nil
+	for _, pin := range o.SPKIPins {
+		digest, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), "sha256/"))
+		if err == nil && len(digest) != sha256.Size {
+			err = fmt.Errorf("pin must be a base64-encoded SHA-256 digest (%d bytes)", sha256.Size)
+		}
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: "auth.tls.spki_pins",
+				Value:   pin,
+				Err:     err,
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		o.PinDigests = append(o.PinDigests, digest)
+	}
+	return nil
+}
+
+// UsernameRewriteRule holds a regular expression used to rewrite usernames.
+type UsernameRewriteRule struct {
+	// Pattern holds the regular expression matched against the username.
+	Pattern string `mapstructure:"pattern"`
+
+	// Regexp is the compiled Pattern.
+	Regexp *regexp.Regexp
+
+	// Replacement holds the replacement for the matched text, which may refer to submatches (eg: ${1}).
+	Replacement string `mapstructure:"replacement"`
+}
+
+// UsernameRulesOptions holds the rules used to normalize usernames before they are sent to Okta.
+//
+// The rules are applied in the following order: Unicode normalization, domain prefix stripping, lowercasing, rewrite
+// rules and finally the default domain.
+type UsernameRulesOptions struct {
+	// DefaultDomain holds the domain appended to usernames which do not contain an '@' (eg: corp.example.com).
+	DefaultDomain string `mapstructure:"default_domain"`
+
+	// Lowercase determines whether or not usernames are converted to lowercase.
+	Lowercase bool `mapstructure:"lowercase"`
+
+	// Rewrites holds the regular expression rewrite rules applied to usernames in order.
+	Rewrites []UsernameRewriteRule `mapstructure:"rewrites"`
+
+	// StripDomainPrefix determines whether or not a Windows domain prefix (eg: CORP\) is removed from usernames.
+	StripDomainPrefix bool `mapstructure:"strip_domain_prefix"`
+
+	// UnicodeNormalize determines whether or not usernames are converted to Unicode normalization form NFKC.
+	UnicodeNormalize bool `mapstructure:"unicode_normalize"`
+}
+
+// Validate checks and saves any configuration settings from viper and ensures that all values are sane.
+//
+// The following errors are returned by this function:
+// ConfigValidateFailure
+func (o *UsernameRulesOptions) Validate() error {
+	o.DefaultDomain = strings.TrimPrefix(strings.TrimSpace(o.DefaultDomain), "@")
+	if strings.ContainsAny(o.DefaultDomain, "@\\ ") {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.username_rules.default_domain",
+			Value:   o.DefaultDomain,
+			Err:     goerrors.New("domain cannot contain '@', '\\' or spaces"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+
+	for i := range o.Rewrites {
+		rule := &o.Rewrites[i]
+		setting := fmt.Sprintf("auth.username_rules.rewrites[%d].pattern", i)
+		if err := requireSetting(rule.Pattern, setting); err != nil {
+			return err
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			e := &errors.ConfigValidateFailure{
+				Setting: setting,
+				Value:   rule.Pattern,
+				Err:     err,
+			}
+			log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+			return e
+		}
+		rule.Regexp = re
+	}
+	return nil
+}
+
 // VersionOptions holds specific settings for the version command.
 type VersionOptions struct {
 	// Short represents a flag used to determine whether to show just the version or not.
@@ -249,6 +1137,52 @@ func (o *VersionOptions) Validate() error {
 	return nil
 }
 
+// isAPIMode determines whether or not the value is a supported Okta API mode.
+func isAPIMode(mode string) bool {
+	for _, m := range apiModeStrings {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOrgURL ensures the organization URL is an absolute HTTP(S) URL and returns it without a trailing slash.
+func parseOrgURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("URL scheme must be 'http' or 'https'")
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("URL must include a hostname")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("URL must not include a query string or fragment")
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// parseDuration parses the duration for the given setting and returns an error if it is invalid or negative.
+func parseDuration(value, setting string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err == nil && duration < 0 {
+		err = goerrors.New("duration cannot be negative")
+	}
+	if err != nil {
+		e := &errors.ConfigValidateFailure{
+			Setting: setting,
+			Value:   value,
+			Err:     err,
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return 0, e
+	}
+	return duration, nil
+}
+
 // parseMFAMethod converts a MFA method string to an actual MFA method value
 func parseMFAMethod(method string) (uint8, error) {
 	normalized := strings.ToLower(method)
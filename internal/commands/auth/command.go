@@ -2,11 +2,13 @@ package auth
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
@@ -44,10 +46,26 @@ func NewCommand() *Command {
 	flags := cmd.Flags()
 
 	// flags stored by viper
+	flags.StringArray("allowed-groups", []string{}, "Okta groups (names or IDs) allowed to connect")
+	viper.BindPFlag("auth.allowed_groups", flags.Lookup("allowed-groups"))
+	viper.BindEnv("auth.allowed_groups", fmt.Sprintf("%sAUTH_ALLOWED_GROUPS", app.EnvVarPrefix))
+
 	flags.String("api-key-file", "", "File containing Okta API key")
 	viper.BindPFlag("auth.api_key_file", flags.Lookup("api-key-file"))
 	viper.BindEnv("auth.api_key_file", fmt.Sprintf("%sAUTH_API_KEY_FILE", app.EnvVarPrefix))
 
+	flags.String("api-mode", app.DefaultAPIMode, "Okta API used to authenticate users (classic, idx or oidc)")
+	viper.BindPFlag("auth.api_mode", flags.Lookup("api-mode"))
+	viper.BindEnv("auth.api_mode", fmt.Sprintf("%sAUTH_API_MODE", app.EnvVarPrefix))
+
+	flags.StringArray("denied-groups", []string{}, "Okta groups (names or IDs) denied from connecting")
+	viper.BindPFlag("auth.denied_groups", flags.Lookup("denied-groups"))
+	viper.BindEnv("auth.denied_groups", fmt.Sprintf("%sAUTH_DENIED_GROUPS", app.EnvVarPrefix))
+
+	flags.StringArray("factor-provider-priority", []string{}, "Order in which to try MFA factor providers")
+	viper.BindPFlag("auth.factor_provider_priority", flags.Lookup("factor-provider-priority"))
+	viper.BindEnv("auth.factor_provider_priority", fmt.Sprintf("%sAUTH_FACTOR_PROVIDER_PRIORITY", app.EnvVarPrefix))
+
 	flags.String("geoip-db-path", "", "Path to MaxMind GeoIP database files")
 	viper.BindPFlag("auth.geoip_db_path", flags.Lookup("geoip-db-path"))
 	viper.BindEnv("auth.geoip_db_path", fmt.Sprintf("%sAUTH_GEOIP_DB_PATH", app.EnvVarPrefix))
@@ -72,6 +90,26 @@ func NewCommand() *Command {
 	viper.BindPFlag("auth.org_name", flags.Lookup("org-name"))
 	viper.BindEnv("auth.org_name", fmt.Sprintf("%sAUTH_ORG_NAME", app.EnvVarPrefix))
 
+	flags.String("org-url", "", "Okta organization base URL (overrides --org-name)")
+	viper.BindPFlag("auth.org_url", flags.Lookup("org-url"))
+	viper.BindEnv("auth.org_url", fmt.Sprintf("%sAUTH_ORG_URL", app.EnvVarPrefix))
+
+	flags.Bool("passcode-fallback", false, "Try the next matching MFA factor when a passcode is rejected")
+	viper.BindPFlag("auth.passcode_fallback", flags.Lookup("passcode-fallback"))
+	viper.BindEnv("auth.passcode_fallback", fmt.Sprintf("%sAUTH_PASSCODE_FALLBACK", app.EnvVarPrefix))
+
+	flags.Bool("remember-device", false, "Ask Okta to remember the client device once MFA succeeds")
+	viper.BindPFlag("auth.remember_device", flags.Lookup("remember-device"))
+	viper.BindEnv("auth.remember_device", fmt.Sprintf("%sAUTH_REMEMBER_DEVICE", app.EnvVarPrefix))
+
+	flags.String("required-app-id", "", "ID of the Okta application users must be assigned to")
+	viper.BindPFlag("auth.required_app_id", flags.Lookup("required-app-id"))
+	viper.BindEnv("auth.required_app_id", fmt.Sprintf("%sAUTH_REQUIRED_APP_ID", app.EnvVarPrefix))
+
+	flags.String("state-dir", app.DefaultStateDir, "Directory in which to store pending MFA transactions")
+	viper.BindPFlag("auth.state_dir", flags.Lookup("state-dir"))
+	viper.BindEnv("auth.state_dir", fmt.Sprintf("%sAUTH_STATE_DIR", app.EnvVarPrefix))
+
 	return cmd
 }
 
@@ -79,20 +117,45 @@ func NewCommand() *Command {
 func (c *Command) runE(cmd *cobra.Command, args []string) error {
 	config := app.Config.Auth
 
+	ctx := cmd.Context()
+
 	// perform interactive authentication test
 	if config.Interactive {
-		return c.doInteractiveAuth()
+		return c.doInteractiveAuth(ctx)
 	}
 
 	// authenticate the user
 	data := "1"
 	req := util.NewOpenVPNClientRequest()
-	client := okta.NewClient()
-	err := client.Authenticate(req)
+	client := newOktaClient(config, req, showNumberChallenge, clearNumberChallenge)
+	_, err := client.Authenticate(ctx, req)
 	if err != nil {
 		data = "0"
 	}
 
+	// send the challenge to the client so the user can respond to it when reconnecting or let the user know why
+	// authentication failed when it is something they need to act upon - the client sends back the username from the
+	// challenge, which is normalized again, so it must be the username the client originally sent
+	reason := ""
+	switch e := err.(type) {
+	case *errors.OktaChallengePending:
+		reason = util.DynamicChallenge(e.StateID, req.OriginalUsername, e.Prompt, true)
+	case interface{ Reason() string }:
+		reason = e.Reason()
+	}
+	if reason != "" {
+		if writeErr := req.SetAuthFailedReason(reason); writeErr != nil {
+			e := &errors.GeneralFailure{
+				Err: writeErr,
+				Msg: fmt.Sprintf("failed to write auth failed reason file '%s': %s", req.AuthFailedReasonFile,
+					writeErr.Error()),
+			}
+			log.Error().Err(e.InternalError()).Str("auth_failed_reason_file", req.AuthFailedReasonFile).
+				Msg(e.Error())
+			return e
+		}
+	}
+
 	// write the status
 	controlFile := os.Getenv("auth_control_file")
 	writeErr := ioutil.WriteFile(controlFile, []byte(data), 0644)
@@ -114,6 +177,9 @@ func (c *Command) postRunE(cmd *cobra.Command, args []string) error {
 
 // preRunE is called before the command is executed.
 func (c *Command) preRunE(cmd *cobra.Command, args []string) error {
+	// tag every message logged while handling this request
+	util.SetCorrelationID()
+
 	// update the list of flags specified on the command-line
 	c.PersistentFlags().VisitAll(func(f *pflag.Flag) {
 		if f.Changed {
@@ -130,13 +196,13 @@ func (c *Command) preRunE(cmd *cobra.Command, args []string) error {
 	if err := app.Config.Auth.Validate(); err != nil {
 		return err
 	}
-	log.Debug().Msgf("'auth' command settings: %+v", app.Config.Auth)
+	log.Debug().Msgf("'auth' command settings: %+v", app.Config.Auth.Redacted())
 	return nil
 }
 
 // doInteractiveAuth performs an interactive authentication and is used for testing configuration settings to make
 // make sure they work.
-func (c *Command) doInteractiveAuth() error {
+func (c *Command) doInteractiveAuth(ctx context.Context) error {
 	// prompt for credentials
 	r := bufio.NewReader(os.Stdin)
 	//w := bufio.NewWriter(os.Stdout)
@@ -208,6 +274,99 @@ func (c *Command) doInteractiveAuth() error {
 
 	// perform the authentication
 	req := util.NewOpenVPNClientRequest()
-	client := okta.NewClient()
-	return client.Authenticate(req)
+	client := newOktaClient(app.Config.Auth, req, func(req *util.OpenVPNClientRequest, number int) {
+		fmt.Printf("Okta Verify: select %d in the push notification to continue\n", number)
+	}, nil)
+	result, err := client.Authenticate(ctx, req)
+
+	// prompt for the response to an MFA challenge and then verify it
+	if e, ok := err.(*errors.OktaChallengePending); ok {
+		fmt.Printf("%s: ", e.Prompt)
+		response, err := r.ReadString('\n')
+		if err != nil {
+			e := &errors.GeneralFailure{
+				Err: err,
+				Msg: fmt.Sprintf("failed to read MFA response from terminal: %s", err.Error()),
+			}
+			log.Error().Err(e.InternalError()).Msg(e.Error())
+			return e
+		}
+		req.ChallengeStateID = e.StateID
+		req.ChallengeResponse = strings.TrimSpace(response)
+		result, err = client.Authenticate(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Authenticated as '%s' (user ID: %s)\n", result.Username, result.UserID)
+	names := make([]string, 0, len(result.Claims))
+	for name := range result.Claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %v\n", name, result.Claims[name])
+	}
+	return nil
+}
+
+// showNumberChallenge displays the number the user must select in Okta Verify to the OpenVPN client.
+//
+// If the client supports CR_TEXT pending authentication, the number is sent to the client right away while the push
+// request is polled.  Otherwise it is sent as the authentication failure reason, which OpenVPN only sends to the
+// client if authentication fails.
+func showNumberChallenge(req *util.OpenVPNClientRequest, number int) {
+	config := app.Config.Auth
+	msg := fmt.Sprintf("Okta Verify: select %d in the push notification to continue", number)
+
+	if req.AuthPendingFile != "" && req.SupportsSSOMethod("crtext") {
+		err := req.SetAuthPending(config.MFATimeout, "crtext", fmt.Sprintf("CR_TEXT:E:%s", msg))
+		if err == nil {
+			return
+		}
+		e := &errors.GeneralFailure{
+			Err: err,
+			Msg: fmt.Sprintf("failed to write auth pending file '%s': %s", req.AuthPendingFile, err.Error()),
+		}
+		log.Error().Err(e.InternalError()).Str("username", req.Username).
+			Str("auth_pending_file", req.AuthPendingFile).Msg(e.Error())
+	}
+	setNumberChallengeReason(req, msg)
+}
+
+// clearNumberChallenge removes the number shown by showNumberChallenge once the push request is approved or
+// rejected so that it is not sent to the client if authentication fails for another reason.
+func clearNumberChallenge(req *util.OpenVPNClientRequest) {
+	setNumberChallengeReason(req, "")
+}
+
+// setNumberChallengeReason writes the number challenge message to the authentication failure reason file, logging
+// any error since it does not affect the authentication itself.
+func setNumberChallengeReason(req *util.OpenVPNClientRequest, msg string) {
+	if err := req.SetAuthFailedReason(msg); err != nil {
+		e := &errors.GeneralFailure{
+			Err: err,
+			Msg: fmt.Sprintf("failed to write auth failed reason file '%s': %s", req.AuthFailedReasonFile, err.Error()),
+		}
+		log.Error().Err(e.InternalError()).Str("username", req.Username).
+			Str("auth_failed_reason_file", req.AuthFailedReasonFile).Msg(e.Error())
+	}
+}
+
+// newOktaClient creates a new Okta API client using the given command settings.
+//
+// If the username in the request was changed by the username rules, the original username is added to every message
+// logged by the client.
+func newOktaClient(config app.AuthOptions, req *util.OpenVPNClientRequest,
+	onNumberChallenge okta.NumberChallengeFunc, onNumberChallengeResolved func(*util.OpenVPNClientRequest)) *okta.Client {
+
+	logCtx := log.With()
+	if req.OriginalUsername != req.Username {
+		logCtx = logCtx.Str("original_username", req.OriginalUsername)
+	}
+	logger := logCtx.Logger()
+	options := okta.NewClientOptions(config, &logger)
+	options.OnNumberChallenge = onNumberChallenge
+	options.OnNumberChallengeResolved = onNumberChallengeResolved
+	return okta.NewClient(options)
 }
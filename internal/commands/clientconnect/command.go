@@ -0,0 +1,274 @@
+package clientconnect
+
+import (
+	goerrors "errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/okta"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.innotegrity.dev/zerolog"
+	"go.innotegrity.dev/zerolog/log"
+)
+
+// Command is the object for executing the actual command.
+type Command struct {
+	cobra.Command
+
+	// unexported variables
+	visitedFlags map[string]bool
+}
+
+// NewCommand creates a new Command object.
+func NewCommand() *Command {
+	cmd := &Command{
+		visitedFlags: map[string]bool{},
+	}
+	cmd.Use = "client-connect CONFIG_FILE"
+	cmd.Short = "Generate OpenVPN client configuration based on Okta group membership."
+	cmd.Long = "This command writes the routes and DHCP options configured for the user's Okta groups to the dynamic " +
+		"client configuration file supplied by OpenVPN's client-connect hook."
+	cmd.Args = cobra.ExactArgs(1)
+	cmd.RunE = cmd.runE
+	// we are not relying on Persistent*RunE functions here so we can better control the order in which functions
+	// are called by sub-commands
+	cmd.PostRunE = cmd.postRunE
+	cmd.PreRunE = cmd.preRunE
+	cmd.SilenceErrors = true
+
+	return cmd
+}
+
+// runE simply executes the command.
+func (c *Command) runE(cmd *cobra.Command, args []string) error {
+	config := app.Config.Auth
+	configFile := args[0]
+	ctx := cmd.Context()
+
+	// OpenVPN only sets the username when the client authenticated using a username and password
+	originalUsername := os.Getenv("username")
+	if originalUsername == "" {
+		originalUsername = os.Getenv("common_name")
+	}
+	username := util.NormalizeUsername(originalUsername, config.UsernameRules)
+	logCtx := log.With().
+		Str("username", username).
+		Str("ip", os.Getenv("untrusted_ip")).
+		Str("config_file", configFile)
+	if originalUsername != username {
+		logCtx = logCtx.Str("original_username", originalUsername)
+	}
+	logger := logCtx.Logger()
+	if username == "" {
+		e := &errors.GeneralFailure{
+			Err: goerrors.New("neither 'username' nor 'common_name' is set"),
+			Msg: "unable to determine the username of the connecting client",
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+
+	// look up the user's groups
+	client := okta.NewClient(okta.NewClientOptions(config, &logger))
+	user, err := client.GetUser(ctx, username)
+	if err != nil {
+		return err
+	}
+	groups, err := client.GetUserGroups(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// write the configuration
+	lines := buildClientConfig(app.Config.ClientConfig, groups)
+	addressLines, err := buildStaticAddressConfig(app.Config.ClientConfig.StaticAddress, user, logger)
+	if err != nil {
+		return err
+	}
+	lines = append(lines, addressLines...)
+	data := ""
+	if len(lines) > 0 {
+		data = strings.Join(lines, "\n") + "\n"
+	}
+	if err := ioutil.WriteFile(configFile, []byte(data), 0600); err != nil {
+		e := &errors.ClientConfigWriteFailure{
+			ConfigFile: configFile,
+			Err:        err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	logger.Info().Str("user_id", user.ID).Int("line_count", len(lines)).Msg("client configuration written")
+	return nil
+}
+
+// postRunE is called after the command is executed.
+func (c *Command) postRunE(cmd *cobra.Command, args []string) error {
+	return nil
+}
+
+// preRunE is called before the command is executed.
+func (c *Command) preRunE(cmd *cobra.Command, args []string) error {
+	// tag every message logged while handling this request
+	util.SetCorrelationID()
+
+	// update the list of flags specified on the command-line
+	c.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			c.visitedFlags[f.Name] = true
+		}
+	})
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			c.visitedFlags[f.Name] = true
+		}
+	})
+
+	// validate options
+	if err := app.Config.Auth.Validate(); err != nil {
+		return err
+	}
+	if app.Config.Auth.APIKey == "" {
+		e := &errors.ConfigValidateFailure{
+			Setting: "auth.api_key_file",
+			Value:   app.Config.Auth.APIKeyFile,
+			Err:     goerrors.New("an API key is required to look up group membership"),
+		}
+		log.Error().Err(e.InternalError()).Str("setting", e.Setting).Interface("value", e.Value).Msg(e.Error())
+		return e
+	}
+	if err := app.Config.ClientConfig.Validate(); err != nil {
+		return err
+	}
+	log.Debug().Msgf("'client-connect' command settings: %+v", app.Config.ClientConfig)
+	return nil
+}
+
+// buildClientConfig returns the OpenVPN configuration lines for a member of the given groups.
+//
+// The default configuration is applied first followed by the configuration for each group the user is a member of in
+// the order the groups are listed in the configuration file.  Duplicate lines are only written once.
+func buildClientConfig(config app.ClientConfigOptions, groups []okta.GroupObject) []string {
+	entries := []app.ClientConfigEntry{config.Default}
+	for _, g := range config.Groups {
+		for _, group := range groups {
+			if g.Group == group.ID || strings.EqualFold(g.Group, group.Profile.Name) {
+				entries = append(entries, g.ClientConfigEntry)
+				break
+			}
+		}
+	}
+
+	lines := []string{}
+	seen := map[string]bool{}
+	add := func(line string) {
+		if !seen[line] {
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	for _, entry := range entries {
+		for _, route := range entry.Routes {
+			add(fmt.Sprintf("push \"route %s\"", strings.Join(strings.Fields(route), " ")))
+		}
+		for _, option := range entry.DHCPOptions {
+			add(fmt.Sprintf("push \"dhcp-option %s\"", strings.Join(strings.Fields(option), " ")))
+		}
+		for _, route := range entry.IRoutes {
+			add(fmt.Sprintf("iroute %s", strings.Join(strings.Fields(route), " ")))
+		}
+	}
+	return lines
+}
+
+// buildStaticAddressConfig returns the OpenVPN configuration lines assigning the static addresses stored in the
+// user's Okta profile.
+//
+// Users without a value in a configured attribute are assigned an address from the server's dynamic pool.
+//
+// The following errors are returned by this function:
+// StaticAddressFailure
+func buildStaticAddressConfig(config app.StaticAddressOptions, user okta.UserObject,
+	logger zerolog.Logger) ([]string, error) {
+	lines := []string{}
+	addresses := []struct {
+		attribute string
+		network   *net.IPNet
+		ipv4      bool
+	}{
+		{config.IPv4Attribute, config.IPv4Network, true},
+		{config.IPv6Attribute, config.IPv6Network, false},
+	}
+	for _, a := range addresses {
+		if a.attribute == "" {
+			continue
+		}
+		value, ok := user.Profile.Attributes[a.attribute]
+		if !ok || value == nil || value == "" {
+			logger.Debug().Str("attribute", a.attribute).Msg("user has no static address assigned")
+			continue
+		}
+
+		var err error
+		s, _ := value.(string)
+		ip := net.ParseIP(strings.TrimSpace(s))
+		switch {
+		case ip == nil:
+			err = fmt.Errorf("'%v' is not a valid IP address", value)
+		case (ip.To4() != nil) != a.ipv4:
+			err = fmt.Errorf("'%s' is not of the expected address family", ip)
+		case !a.network.Contains(ip) || ip.Equal(a.network.IP):
+			err = fmt.Errorf("'%s' is not within the server address pool '%s'", ip, a.network)
+		case ip.Equal(poolAddress(a.network, 1)):
+			err = fmt.Errorf("'%s' is reserved for the OpenVPN server", ip)
+		case a.ipv4 && ip.Equal(broadcastAddress(a.network)):
+			err = fmt.Errorf("'%s' is the broadcast address of the server address pool '%s'", ip, a.network)
+		}
+		if err != nil {
+			e := &errors.StaticAddressFailure{
+				Attribute: a.attribute,
+				Username:  user.Profile.Login,
+				Err:       err,
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return nil, e
+		}
+
+		if a.ipv4 {
+			lines = append(lines, fmt.Sprintf("ifconfig-push %s %s", ip, net.IP(a.network.Mask)))
+		} else {
+			ones, _ := a.network.Mask.Size()
+			lines = append(lines, fmt.Sprintf("ifconfig-ipv6-push %s/%d", ip, ones))
+		}
+		logger.Info().Str("attribute", a.attribute).Str("address", ip.String()).Msg("static address assigned")
+	}
+	return lines, nil
+}
+
+// poolAddress returns the address at the given offset from the start of the network.
+func poolAddress(network *net.IPNet, offset int) net.IP {
+	ip := make(net.IP, len(network.IP))
+	copy(ip, network.IP)
+	for i := len(ip) - 1; i >= 0 && offset > 0; i-- {
+		sum := int(ip[i]) + offset
+		ip[i] = byte(sum)
+		offset = sum >> 8
+	}
+	return ip
+}
+
+// broadcastAddress returns the last address in the network.
+func broadcastAddress(network *net.IPNet) net.IP {
+	ip := make(net.IP, len(network.IP))
+	for i := range ip {
+		ip[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return ip
+}
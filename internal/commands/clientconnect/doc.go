@@ -0,0 +1,6 @@
+// Package clientconnect implements the 'client-connect' sub-command.
+//
+// The 'client-connect' command is run by OpenVPN's client-connect script hook once a user has been authenticated. It
+// writes the routes, DHCP options and internal routes configured for the user's Okta groups to the dynamic client
+// configuration file supplied by OpenVPN.
+package clientconnect
@@ -0,0 +1,24 @@
+package errors
+
+import "fmt"
+
+// AuthorizationFailure occurs when an authenticated user is not permitted to connect to the VPN.
+type AuthorizationFailure struct {
+	Username string
+	Reason   string
+}
+
+// InternalError returns the internal error object.
+func (e *AuthorizationFailure) InternalError() error {
+	return fmt.Errorf("%s", e.Reason)
+}
+
+// Error returns the string version of the error.
+func (e *AuthorizationFailure) Error() string {
+	return fmt.Sprintf("user '%s' is not authorized to connect: %s", e.Username, e.Reason)
+}
+
+// Code returns the corresponding error code.
+func (e *AuthorizationFailure) Code() int {
+	return AuthorizationFailureCode
+}
@@ -0,0 +1,47 @@
+package errors
+
+import "fmt"
+
+// ClientConfigWriteFailure occurs when an error is detected while writing the OpenVPN client configuration file.
+type ClientConfigWriteFailure struct {
+	ConfigFile string
+	Err        error
+}
+
+// InternalError returns the internal error object.
+func (e *ClientConfigWriteFailure) InternalError() error {
+	return e.Err
+}
+
+// Error returns the string version of the error.
+func (e *ClientConfigWriteFailure) Error() string {
+	return fmt.Sprintf("error while writing client configuration file '%s': %s", e.ConfigFile, e.Err.Error())
+}
+
+// Code returns the corresponding error code.
+func (e *ClientConfigWriteFailure) Code() int {
+	return ClientConfigWriteFailureCode
+}
+
+// StaticAddressFailure occurs when the static VPN address assigned to a user in Okta cannot be used.
+type StaticAddressFailure struct {
+	Attribute string
+	Username  string
+	Err       error
+}
+
+// InternalError returns the internal error object.
+func (e *StaticAddressFailure) InternalError() error {
+	return e.Err
+}
+
+// Error returns the string version of the error.
+func (e *StaticAddressFailure) Error() string {
+	return fmt.Sprintf("invalid static address in profile attribute '%s' for user '%s': %s", e.Attribute,
+		e.Username, e.Err.Error())
+}
+
+// Code returns the corresponding error code.
+func (e *StaticAddressFailure) Code() int {
+	return StaticAddressFailureCode
+}
@@ -17,7 +17,31 @@ const (
 	GeoIPLookupFailureCode   = 42
 
 	// Okta errors (61-80)
-	OktaRequestFailureCode  = 61
-	OktaResponseFailureCode = 62
-	OktaAuthFailureCode     = 63
+	OktaRequestFailureCode         = 61
+	OktaResponseFailureCode        = 62
+	OktaAuthFailureCode            = 63
+	OktaAuthCanceledCode           = 64
+	OktaRateLimitedCode            = 65
+	OktaChallengePendingCode       = 66
+	OktaTokenInvalidCode           = 67
+	OktaAccountLockedOutCode       = 68
+	OktaPasswordResetRequiredCode  = 69
+	OktaRecoveryInProgressCode     = 70
+	OktaRecoveryChallengeCode      = 71
+	OktaMFAEnrollRequiredCode      = 72
+	OktaMFAEnrollActivateCode      = 73
+	OktaUnauthenticatedCode        = 74
+	OktaMFAChallengeIncompleteCode = 75
+	OktaProxyFailureCode           = 76
+	OktaCertificatePinFailureCode  = 77
+
+	// state errors (81-100)
+	StateStoreFailureCode = 81
+
+	// authorization errors (101-120)
+	AuthorizationFailureCode = 101
+
+	// client configuration errors (121-140)
+	ClientConfigWriteFailureCode = 121
+	StaticAddressFailureCode     = 122
 )
@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // OktaRequestFailure occurs when an error is detected while decoding a response from the Okta API.
 type OktaRequestFailure struct {
@@ -63,3 +66,334 @@ func (e *OktaAuthFailure) Error() string {
 func (e *OktaAuthFailure) Code() int {
 	return OktaAuthFailureCode
 }
+
+// OktaAuthCanceled occurs when authentication is aborted because its context was canceled or timed out.
+type OktaAuthCanceled struct {
+	Err error
+}
+
+// InternalError returns the internal error object.
+func (e *OktaAuthCanceled) InternalError() error {
+	return e.Err
+}
+
+// Error returns the string version of the error.
+func (e *OktaAuthCanceled) Error() string {
+	return fmt.Sprintf("Okta authentication was aborted: %s", e.Err.Error())
+}
+
+// Code returns the corresponding error code.
+func (e *OktaAuthCanceled) Code() int {
+	return OktaAuthCanceledCode
+}
+
+// OktaRateLimited occurs when the Okta API rate limit has been exceeded and the request could not be retried in time.
+type OktaRateLimited struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// InternalError returns the internal error object.
+func (e *OktaRateLimited) InternalError() error {
+	return fmt.Errorf("HTTP 429 Too Many Requests (limit %d, remaining %d)", e.Limit, e.Remaining)
+}
+
+// Error returns the string version of the error.
+func (e *OktaRateLimited) Error() string {
+	return fmt.Sprintf("Okta API rate limit exceeded; the limit resets at %s", e.Reset.Format(time.RFC3339))
+}
+
+// Code returns the corresponding error code.
+func (e *OktaRateLimited) Code() int {
+	return OktaRateLimitedCode
+}
+
+// OktaChallengePending occurs when an MFA challenge has been sent to the user and authentication cannot complete until
+// the user responds to it on a subsequent connection attempt.
+type OktaChallengePending struct {
+	Username   string
+	FactorType string
+	StateID    string
+	Prompt     string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaChallengePending) InternalError() error {
+	return fmt.Errorf("waiting for response to '%s' challenge (state ID %s)", e.FactorType, e.StateID)
+}
+
+// Error returns the string version of the error.
+func (e *OktaChallengePending) Error() string {
+	return fmt.Sprintf("MFA challenge sent to user '%s'; waiting for a response", e.Username)
+}
+
+// Code returns the corresponding error code.
+func (e *OktaChallengePending) Code() int {
+	return OktaChallengePendingCode
+}
+
+// OktaTokenInvalid occurs when a token issued by Okta fails verification.
+type OktaTokenInvalid struct {
+	Username string
+	Err      error
+}
+
+// InternalError returns the internal error object.
+func (e *OktaTokenInvalid) InternalError() error {
+	return e.Err
+}
+
+// Error returns the string version of the error.
+func (e *OktaTokenInvalid) Error() string {
+	return fmt.Sprintf("ID token issued for user '%s' is invalid: %s", e.Username, e.Err.Error())
+}
+
+// Code returns the corresponding error code.
+func (e *OktaTokenInvalid) Code() int {
+	return OktaTokenInvalidCode
+}
+
+// OktaProxyFailure occurs when an Okta API request fails because the HTTP proxy could not be reached or refused to
+// forward the request.
+type OktaProxyFailure struct {
+	ProxyURL string
+	Err      error
+}
+
+// InternalError returns the internal error object.
+func (e *OktaProxyFailure) InternalError() error {
+	return e.Err
+}
+
+// Error returns the string version of the error.
+func (e *OktaProxyFailure) Error() string {
+	return fmt.Sprintf("error while connecting to Okta through proxy '%s': %s", e.ProxyURL, e.Err.Error())
+}
+
+// Code returns the corresponding error code.
+func (e *OktaProxyFailure) Code() int {
+	return OktaProxyFailureCode
+}
+
+// OktaCertificatePinFailure occurs when none of the certificates presented by an Okta server match the configured
+// SPKI pins.
+type OktaCertificatePinFailure struct {
+	Host string
+	Err  error
+}
+
+// InternalError returns the internal error object.
+func (e *OktaCertificatePinFailure) InternalError() error {
+	return e.Err
+}
+
+// Error returns the string version of the error.
+func (e *OktaCertificatePinFailure) Error() string {
+	return fmt.Sprintf("certificate presented by '%s' does not match any pinned public key: %s", e.Host, e.Err.Error())
+}
+
+// Code returns the corresponding error code.
+func (e *OktaCertificatePinFailure) Code() int {
+	return OktaCertificatePinFailureCode
+}
+
+// OktaAccountLockedOut occurs when the user's Okta account is locked out.
+type OktaAccountLockedOut struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaAccountLockedOut) InternalError() error {
+	return fmt.Errorf("transaction status was 'LOCKED_OUT': the user's account is locked out")
+}
+
+// Error returns the string version of the error.
+func (e *OktaAccountLockedOut) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': account is locked out", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaAccountLockedOut) Reason() string {
+	return "Your account is locked. Please contact your administrator."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaAccountLockedOut) Code() int {
+	return OktaAccountLockedOutCode
+}
+
+// OktaPasswordResetRequired occurs when the user must reset their password before authenticating.
+type OktaPasswordResetRequired struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaPasswordResetRequired) InternalError() error {
+	return fmt.Errorf("transaction status was 'PASSWORD_RESET': the user's password must be reset")
+}
+
+// Error returns the string version of the error.
+func (e *OktaPasswordResetRequired) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': password must be reset", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaPasswordResetRequired) Reason() string {
+	return "Your password must be reset before you can connect."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaPasswordResetRequired) Code() int {
+	return OktaPasswordResetRequiredCode
+}
+
+// OktaRecoveryInProgress occurs when the user has started recovering their account.
+type OktaRecoveryInProgress struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaRecoveryInProgress) InternalError() error {
+	return fmt.Errorf("transaction status was 'RECOVERY': the user's account is in recovery")
+}
+
+// Error returns the string version of the error.
+func (e *OktaRecoveryInProgress) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': account recovery is in progress", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaRecoveryInProgress) Reason() string {
+	return "Your account is being recovered. Please finish recovering it before connecting."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaRecoveryInProgress) Code() int {
+	return OktaRecoveryInProgressCode
+}
+
+// OktaRecoveryChallenge occurs when the user must verify a recovery challenge sent by Okta.
+type OktaRecoveryChallenge struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaRecoveryChallenge) InternalError() error {
+	return fmt.Errorf("transaction status was 'RECOVERY_CHALLENGE': the user must verify an account recovery challenge")
+}
+
+// Error returns the string version of the error.
+func (e *OktaRecoveryChallenge) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': account recovery challenge must be verified", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaRecoveryChallenge) Reason() string {
+	return "Please verify the account recovery code sent to you before connecting."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaRecoveryChallenge) Code() int {
+	return OktaRecoveryChallengeCode
+}
+
+// OktaMFAEnrollRequired occurs when the user must enroll an MFA factor before authenticating.
+type OktaMFAEnrollRequired struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaMFAEnrollRequired) InternalError() error {
+	return fmt.Errorf("transaction status was 'MFA_ENROLL': the user must enroll an MFA factor")
+}
+
+// Error returns the string version of the error.
+func (e *OktaMFAEnrollRequired) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': MFA enrollment is required", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaMFAEnrollRequired) Reason() string {
+	return "You must set up multifactor authentication in Okta before you can connect."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaMFAEnrollRequired) Code() int {
+	return OktaMFAEnrollRequiredCode
+}
+
+// OktaMFAEnrollActivate occurs when the user has enrolled an MFA factor which has not yet been activated.
+type OktaMFAEnrollActivate struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaMFAEnrollActivate) InternalError() error {
+	return fmt.Errorf("transaction status was 'MFA_ENROLL_ACTIVATE': the user must activate an enrolled MFA factor")
+}
+
+// Error returns the string version of the error.
+func (e *OktaMFAEnrollActivate) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': MFA factor activation is required", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaMFAEnrollActivate) Reason() string {
+	return "Please finish setting up multifactor authentication in Okta before connecting."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaMFAEnrollActivate) Code() int {
+	return OktaMFAEnrollActivateCode
+}
+
+// OktaUnauthenticated occurs when Okta reports that the user has not been authenticated.
+type OktaUnauthenticated struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaUnauthenticated) InternalError() error {
+	return fmt.Errorf("transaction status was 'UNAUTHENTICATED': the user has not been authenticated")
+}
+
+// Error returns the string version of the error.
+func (e *OktaUnauthenticated) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': user was not authenticated", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaUnauthenticated) Reason() string {
+	return "Authentication could not be completed. Please try again."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaUnauthenticated) Code() int {
+	return OktaUnauthenticatedCode
+}
+
+// OktaMFAChallengeIncomplete occurs when the user did not complete an MFA challenge.
+type OktaMFAChallengeIncomplete struct {
+	Username string
+}
+
+// InternalError returns the internal error object.
+func (e *OktaMFAChallengeIncomplete) InternalError() error {
+	return fmt.Errorf("transaction status was 'MFA_CHALLENGE': the MFA challenge was not completed")
+}
+
+// Error returns the string version of the error.
+func (e *OktaMFAChallengeIncomplete) Error() string {
+	return fmt.Sprintf("authentication failed for user '%s': MFA challenge was not completed", e.Username)
+}
+
+// Reason returns the reason authentication failed suitable for displaying to the user.
+func (e *OktaMFAChallengeIncomplete) Reason() string {
+	return "Multifactor authentication was not completed. Please try again."
+}
+
+// Code returns the corresponding error code.
+func (e *OktaMFAChallengeIncomplete) Code() int {
+	return OktaMFAChallengeIncompleteCode
+}
@@ -0,0 +1,24 @@
+package errors
+
+import "fmt"
+
+// StateStoreFailure occurs when an error is detected while reading or writing the local state store.
+type StateStoreFailure struct {
+	Key string
+	Err error
+}
+
+// InternalError returns the internal error object.
+func (e *StateStoreFailure) InternalError() error {
+	return e.Err
+}
+
+// Error returns the string version of the error.
+func (e *StateStoreFailure) Error() string {
+	return fmt.Sprintf("error while accessing saved state '%s': %s", e.Key, e.Err.Error())
+}
+
+// Code returns the corresponding error code.
+func (e *StateStoreFailure) Code() int {
+	return StateStoreFailureCode
+}
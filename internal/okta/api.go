@@ -6,12 +6,19 @@ import (
 
 // API constants.
 const (
+	APIBasePath                  = "/api/v1"
 	AuthExceptionCode            = "E000004"
-	OktaAPIBaseURL               = "https://%s.okta.com/api/v1"
+	InvalidPasscodeExceptionCode = "E0000068"
 	PasswordExpiredExceptionCode = "E000064"
 	PasswordExpiredSummary       = "Password is expired and must be changed."
 )
 
+// ChallengeObject contains information about an outstanding factor challenge.
+type ChallengeObject struct {
+	// CorrectAnswer is the number the user must select in Okta Verify when number matching is required.
+	CorrectAnswer *int `json:"correctAnswer"`
+}
+
 // EmbeddedResource contains embedded resource information.
 type EmbeddedResource struct {
 	User    UserObject     `json:"user"`
@@ -35,15 +42,55 @@ type FactorObject struct {
 	Provider   string                  `json:"provider"`
 	VendorName string                  `json:"vendorsName"`
 	Profile    json.RawMessage         `json:"profile"`
+	Embedded   FactorEmbeddedResource  `json:"_embedded"`
 	Links      map[string]LinkResource `json:"_links"`
 }
 
+// FactorEmbeddedResource contains resources embedded in a factor.
+type FactorEmbeddedResource struct {
+	Challenge ChallengeObject `json:"challenge"`
+}
+
+// GroupObject holds information about a group.
+type GroupObject struct {
+	ID      string       `json:"id"`
+	Type    string       `json:"type"`
+	Profile GroupProfile `json:"profile"`
+}
+
+// GroupProfile holds group profile information such as the name and description.
+type GroupProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
 // LinkResource describes links to other resources or API calls.
 type LinkResource struct {
+	Name  string              `json:"name"`
 	Href  string              `json:"href"`
 	Hints map[string][]string `json:"hints"`
 }
 
+// LinkResources holds one or more links for the same relation.
+//
+// Okta returns a single link object for most relations but an array of link objects for some (eg: resend).
+type LinkResources []LinkResource
+
+// UnmarshalJSON decodes either a single link object or an array of link objects.
+func (l *LinkResources) UnmarshalJSON(data []byte) error {
+	var links []LinkResource
+	if err := json.Unmarshal(data, &links); err == nil {
+		*l = links
+		return nil
+	}
+	var link LinkResource
+	if err := json.Unmarshal(data, &link); err != nil {
+		return err
+	}
+	*l = LinkResources{link}
+	return nil
+}
+
 // PolicyObject contains  policy information.
 type PolicyObject struct {
 	AllowRememberDevice             bool            `json:"allowRememberDevice"`
@@ -63,10 +110,17 @@ type PrimaryAuthResponse struct {
 
 // SecondaryAuthResponse contains secondary authentication information when MFA succeeds.
 type SecondaryAuthResponse struct {
-	ExpiresAt    string `json:"expiresAt"`
-	Status       string `json:"status"`
-	FactorResult string `json:"factorResult"`
-	SessionToken string `json:"sessionToken"`
+	ExpiresAt    string                    `json:"expiresAt"`
+	Status       string                    `json:"status"`
+	FactorResult string                    `json:"factorResult"`
+	SessionToken string                    `json:"sessionToken"`
+	Embedded     SecondaryEmbeddedResource `json:"_embedded"`
+	Links        map[string]LinkResources  `json:"_links"`
+}
+
+// SecondaryEmbeddedResource contains resources embedded in a secondary authentication response.
+type SecondaryEmbeddedResource struct {
+	Factor FactorObject `json:"factor"`
 }
 
 // UserObject holds information about a user.
@@ -83,4 +137,21 @@ type UserProfile struct {
 	LastName  string `json:"lastName"`
 	Locale    string `json:"locale"`
 	TimeZone  string `json:"timeZone"`
+
+	// Attributes holds every attribute in the profile, including custom attributes, keyed by attribute name.
+	Attributes map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON decodes the profile along with all of its attributes.
+func (p *UserProfile) UnmarshalJSON(data []byte) error {
+	type profile UserProfile
+	var v profile
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &v.Attributes); err != nil {
+		return err
+	}
+	*p = UserProfile(v)
+	return nil
 }
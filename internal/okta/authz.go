@@ -0,0 +1,239 @@
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	"go.innotegrity.dev/zerolog"
+	"gopkg.in/resty.v1"
+)
+
+// Authorization constants.
+const (
+	// AppAssignmentCacheLifetime is how long a user's assignment to the required application is cached.
+	AppAssignmentCacheLifetime = 5 * time.Minute
+
+	// AppAssignmentKeyPrefix is the prefix for state store keys holding cached application assignments.
+	AppAssignmentKeyPrefix = "app-"
+
+	// GroupsPageSize is the number of groups requested per page when looking up a user's groups.
+	GroupsPageSize = 200
+)
+
+// nextLinkRegex matches the link to the next page of results in a Link header.
+var nextLinkRegex = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// authorize checks the user's assignment to the required application and their group memberships against the allowed
+// and denied groups.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled, AuthorizationFailure
+func (c *Client) authorize(ctx context.Context, req *util.OpenVPNClientRequest, result *AuthResult) error {
+	groupsRequired := len(c.options.AllowedGroups) > 0 || len(c.options.DeniedGroups) > 0
+	if !groupsRequired && c.options.RequiredAppID == "" {
+		return nil
+	}
+	logger := c.logger.With().
+		Str("username", req.Username).
+		Str("ip", req.ClientIP).
+		Str("location", req.Location).
+		Str("user_id", result.UserID).
+		Logger()
+
+	if result.UserID == "" {
+		e := &errors.AuthorizationFailure{
+			Username: req.Username,
+			Reason:   "Okta user ID is unknown so authorization cannot be checked",
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	if c.options.RequiredAppID != "" {
+		if err := c.checkAppAssignment(ctx, req, result.UserID, logger); err != nil {
+			return err
+		}
+	}
+	if !groupsRequired {
+		return nil
+	}
+	return c.checkGroups(ctx, req, result.UserID, logger)
+}
+
+// checkAppAssignment verifies that the user is assigned to the required application.
+//
+// Successful checks are cached so that reconnecting users do not require another API call.  Failures to read or write
+// the cache are logged but do not prevent the user from connecting.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled, AuthorizationFailure
+func (c *Client) checkAppAssignment(ctx context.Context, req *util.OpenVPNClientRequest, userID string,
+	logger zerolog.Logger) error {
+
+	logger = logger.With().
+		Str("app_id", c.options.RequiredAppID).
+		Logger()
+
+	// check the cache first
+	var assigned bool
+	key := fmt.Sprintf("%s%s-%s", AppAssignmentKeyPrefix, c.options.RequiredAppID, userID)
+	found, err := c.options.StateStore.Load(key, &assigned)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to read cached application assignment; looking it up again")
+	}
+	if found && assigned {
+		logger.Info().Msg("user is assigned to the required application (cached)")
+		return nil
+	}
+
+	// look up the assignment
+	link := c.apiURL(fmt.Sprintf("/apps/%s/users/%s", url.PathEscape(c.options.RequiredAppID),
+		url.PathEscape(userID)))
+	resp, err := c.getRequest(ctx, link)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode() {
+	case 200:
+		logger.Info().Msg("user is assigned to the required application")
+		if err := c.options.StateStore.Save(key, true, time.Now().Add(AppAssignmentCacheLifetime)); err != nil {
+			logger.Warn().Err(err).Msg("failed to cache application assignment")
+		}
+		return nil
+
+	case 404:
+		e := &errors.AuthorizationFailure{
+			Username: req.Username,
+			Reason:   fmt.Sprintf("user is not assigned to the required Okta application '%s'", c.options.RequiredAppID),
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	var r ErrorResponse
+	json.Unmarshal(resp.Body(), &r)
+	e := &errors.OktaResponseFailure{
+		Err: fmt.Errorf("failed to retrieve application assignment: HTTP status '%s': %s (%s)", resp.Status(),
+			r.ErrorSummary, r.ErrorCode),
+	}
+	logger.Error().Err(e.InternalError()).Msg(e.Error())
+	return e
+}
+
+// checkGroups checks the user's group memberships against the allowed and denied groups.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled, AuthorizationFailure
+func (c *Client) checkGroups(ctx context.Context, req *util.OpenVPNClientRequest, userID string,
+	logger zerolog.Logger) error {
+
+	groups, err := c.getUserGroups(ctx, userID, logger)
+	if err != nil {
+		return err
+	}
+
+	// members of any denied group are rejected even if they belong to an allowed group
+	for _, g := range groups {
+		if matchesGroup(g, c.options.DeniedGroups) {
+			e := &errors.AuthorizationFailure{
+				Username: req.Username,
+				Reason:   fmt.Sprintf("user is a member of denied group '%s' (%s)", g.Profile.Name, g.ID),
+			}
+			logger.Error().Err(e.InternalError()).Str("group_id", g.ID).Str("group_name", g.Profile.Name).
+				Msg(e.Error())
+			return e
+		}
+	}
+	if len(c.options.AllowedGroups) == 0 {
+		logger.Info().Msg("user is not a member of any denied group")
+		return nil
+	}
+	for _, g := range groups {
+		if matchesGroup(g, c.options.AllowedGroups) {
+			logger.Info().Str("group_id", g.ID).Str("group_name", g.Profile.Name).
+				Msgf("user is authorized by membership in group '%s'", g.Profile.Name)
+			return nil
+		}
+	}
+	e := &errors.AuthorizationFailure{
+		Username: req.Username,
+		Reason:   "user is not a member of any allowed group",
+	}
+	logger.Error().Err(e.InternalError()).Msg(e.Error())
+	return e
+}
+
+// getUserGroups returns all of the groups the user belongs to, following pagination links as needed.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) getUserGroups(ctx context.Context, userID string, logger zerolog.Logger) ([]GroupObject, error) {
+	groups := []GroupObject{}
+	link := c.apiURL(fmt.Sprintf("/users/%s/groups?limit=%d", url.PathEscape(userID), GroupsPageSize))
+	for link != "" {
+		resp, err := c.getRequest(ctx, link)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode() != 200 {
+			var r ErrorResponse
+			json.Unmarshal(resp.Body(), &r)
+			e := &errors.OktaResponseFailure{
+				Err: fmt.Errorf("failed to retrieve user groups: HTTP status '%s': %s (%s)", resp.Status(),
+					r.ErrorSummary, r.ErrorCode),
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return nil, e
+		}
+		var page []GroupObject
+		if err := json.Unmarshal(resp.Body(), &page); err != nil {
+			e := &errors.OktaResponseFailure{
+				Err: err,
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return nil, e
+		}
+		groups = append(groups, page...)
+
+		link, err = c.nextLink(resp)
+		if err != nil {
+			e := &errors.OktaResponseFailure{
+				Err: err,
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return nil, e
+		}
+	}
+	logger.Debug().Int("group_count", len(groups)).Msg("retrieved user groups")
+	return groups, nil
+}
+
+// nextLink returns the link to the next page of results from the response's Link headers or an empty string if this
+// is the last page.
+func (c *Client) nextLink(resp *resty.Response) (string, error) {
+	for _, header := range resp.Header()["Link"] {
+		for _, value := range strings.Split(header, ",") {
+			if matches := nextLinkRegex.FindStringSubmatch(value); matches != nil {
+				return c.resolveLink(matches[1])
+			}
+		}
+	}
+	return "", nil
+}
+
+// matchesGroup determines whether or not the group's ID or name is in the given list.
+//
+// IDs are matched exactly while names are matched case-insensitively.
+func matchesGroup(group GroupObject, list []string) bool {
+	for _, g := range list {
+		if g == group.ID || strings.EqualFold(g, group.Profile.Name) {
+			return true
+		}
+	}
+	return false
+}
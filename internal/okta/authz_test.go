@@ -0,0 +1,131 @@
+package okta
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+)
+
+// newAuthzTestServer returns a handler which authenticates every user and returns their groups and application
+// assignments.
+//
+// The groups are returned one per page to exercise pagination.
+func newAuthzTestServer(groups []string, assignedApps ...string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "SUCCESS",
+			"_embedded": map[string]interface{}{
+				"user": map[string]interface{}{
+					"id": "00u1",
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/users/00u1/groups", func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		fmt.Sscanf(r.URL.Query().Get("after"), "%d", &page)
+		if page+1 < len(groups) {
+			// links use the canonical domain and must be rewritten to the test server
+			w.Header().Set("Link", fmt.Sprintf(`<https://example.okta.com/api/v1/users/00u1/groups?limit=1>; `+
+				`rel="self", <https://example.okta.com/api/v1/users/00u1/groups?after=%d&limit=1>; rel="next"`, page+1))
+		}
+		pageGroups := []interface{}{}
+		if page < len(groups) {
+			pageGroups = append(pageGroups, map[string]interface{}{
+				"id": fmt.Sprintf("00g%d", page),
+				"profile": map[string]interface{}{
+					"name": groups[page],
+				},
+			})
+		}
+		writeJSON(w, http.StatusOK, pageGroups)
+	})
+	for _, appID := range assignedApps {
+		mux.HandleFunc(fmt.Sprintf("/api/v1/apps/%s/users/00u1", appID), func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"id": "00u1",
+			})
+		})
+	}
+	return mux
+}
+
+func TestAuthorizeGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		success bool
+	}{
+		{"allowed group on last page", []string{"VPN Users"}, nil, true},
+		{"allowed group by ID", []string{"00g1"}, nil, true},
+		{"not in allowed group", []string{"Admins"}, nil, false},
+		{"denied group", []string{"VPN Users"}, []string{"contractors"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := newAuthzTestServer([]string{"Everyone", "Contractors", "VPN Users"})
+			client := newTestClient(t, handler, ClientOptions{
+				AllowedGroups: test.allowed,
+				APIKey:        "key",
+				DeniedGroups:  test.denied,
+			})
+
+			_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+				Username: "jdoe@example.com",
+				Password: "secret",
+			})
+			if test.success && err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if _, ok := err.(*errors.AuthorizationFailure); !test.success && !ok {
+				t.Fatalf("expected AuthorizationFailure but got %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeAppAssignment(t *testing.T) {
+	// a state directory which cannot be created must not deny access to assigned users
+	file := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(file, []byte{}, 0600); err != nil {
+		t.Fatalf("failed to create file: %s", err.Error())
+	}
+	tests := []struct {
+		name    string
+		appID   string
+		store   *util.StateStore
+		success bool
+	}{
+		{"assigned", "0oa1", util.NewStateStore(t.TempDir()), true},
+		{"assigned with cache failure", "0oa1", util.NewStateStore(filepath.Join(file, "state")), true},
+		{"not assigned", "0oa2", util.NewStateStore(t.TempDir()), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, newAuthzTestServer(nil, "0oa1"), ClientOptions{
+				APIKey:        "key",
+				RequiredAppID: test.appID,
+			})
+			client.options.StateStore = test.store
+
+			_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+				Username: "jdoe@example.com",
+				Password: "secret",
+			})
+			if test.success && err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if _, ok := err.(*errors.AuthorizationFailure); !test.success && !ok {
+				t.Fatalf("expected AuthorizationFailure but got %T: %v", err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package okta
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	"go.innotegrity.dev/zerolog"
+)
+
+// Challenge constants.
+const (
+	// DefaultTransactionLifetime is how long a pending transaction is kept if Okta does not specify an expiration.
+	DefaultTransactionLifetime = 5 * time.Minute
+
+	// InvalidResponsePrompt is displayed before the prompt when the user must respond to a challenge again because
+	// their previous response was invalid.
+	InvalidResponsePrompt = "Invalid code."
+
+	// ResendResponse is the response a user enters to have the challenge sent again.
+	ResendResponse = "resend"
+
+	// TransactionKeyPrefix is the prefix for state store keys holding pending transactions.
+	TransactionKeyPrefix = "txn-"
+)
+
+// challengeFactorTypes holds the factor types which send a challenge in the order they are used by default.
+var challengeFactorTypes = []string{"sms", "call", "email"}
+
+// challengeFactorMethods maps each factor type which sends a challenge to the MFA method which enables it.
+var challengeFactorMethods = map[string]uint8{
+	"call":  app.MFACall,
+	"email": app.MFAEmail,
+	"sms":   app.MFASMS,
+}
+
+// challengePrompts holds the prompt displayed to the user for each factor type which sends a challenge.
+var challengePrompts = map[string]string{
+	"call":  "Enter the code from the phone call",
+	"email": "Enter the code sent to your email",
+	"sms":   "Enter the code sent to your phone",
+}
+
+// pendingTransaction holds an authentication transaction which is waiting for the user to respond to an MFA
+// challenge on a subsequent connection attempt.
+type pendingTransaction struct {
+	APIMode    string `json:"apiMode"`
+	FactorType string `json:"factorType"`
+	Prompt     string `json:"prompt"`
+	ResendLink string `json:"resendLink"`
+	StateToken string `json:"stateToken"`
+	UserID     string `json:"userId"`
+	Username   string `json:"username"`
+	VerifyLink string `json:"verifyLink"`
+}
+
+// sendChallenge asks Okta to send an MFA challenge (eg: an SMS code) to the user and saves the transaction so that
+// the user's response can be verified on the next connection attempt.
+//
+// On success, an OktaChallengePending error is returned which contains the state ID of the saved transaction.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure
+func (c *Client) sendChallenge(ctx context.Context, req *util.OpenVPNClientRequest, factorType string,
+	pr PrimaryAuthResponse) error {
+
+	logger := c.logger.With().
+		Str("mfa_method", factorType).
+		Logger()
+
+	// get the verification link
+	link, err := c.getFactorVerifyLink(factorType, pr, c.rememberDevice(req, pr))
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+
+	// POST the verification request without a passcode to send the challenge
+	resp, err := c.postRequest(ctx, req, link, map[string]interface{}{
+		"stateToken": pr.StateToken,
+	}, false)
+	if err != nil {
+		return err
+	}
+	logger = logger.With().Str("okta_request_id", oktaRequestID(resp)).Logger()
+	if logger.IsDebugEnabled() {
+		fullResponse := spew.Sdump(resp)
+		logger.Debug().Str("response", fullResponse).Msgf("MFA challenge response: %s", fullResponse)
+	}
+	sr, err := c.parseSecondaryAuthResponse(req, resp, logger)
+	if err != nil {
+		return err
+	}
+
+	// save the transaction under a new state ID
+	stateID, err := newStateID()
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: fmt.Errorf("failed to generate transaction state ID: %s", err.Error()),
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	txn := pendingTransaction{
+		FactorType: factorType,
+		Prompt:     challengePrompts[factorType],
+		StateToken: pr.StateToken,
+		UserID:     pr.Embedded.User.ID,
+		Username:   req.Username,
+		VerifyLink: link,
+	}
+	return c.saveChallenge(req, stateID, txn, sr, pr.ExpiresAt, logger)
+}
+
+// resendChallenge asks Okta to send the MFA challenge for a pending transaction to the user again.
+//
+// On success, an OktaChallengePending error is returned which contains the state ID of the saved transaction.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure
+func (c *Client) resendChallenge(ctx context.Context, req *util.OpenVPNClientRequest, txn pendingTransaction,
+	logger zerolog.Logger) error {
+
+	if txn.ResendLink == "" {
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    AuthExceptionCode,
+			ErrorSummary: fmt.Sprintf("'%s' MFA challenge cannot be resent", txn.FactorType),
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Msg(e.Error())
+		return e
+	}
+
+	logger.Info().Msg("resending MFA challenge")
+	resp, err := c.postRequest(ctx, req, txn.ResendLink, map[string]interface{}{
+		"stateToken": txn.StateToken,
+	}, false)
+	if err != nil {
+		return err
+	}
+	logger = logger.With().Str("okta_request_id", oktaRequestID(resp)).Logger()
+	if logger.IsDebugEnabled() {
+		fullResponse := spew.Sdump(resp)
+		logger.Debug().Str("response", fullResponse).Msgf("MFA challenge response: %s", fullResponse)
+	}
+	sr, err := c.parseSecondaryAuthResponse(req, resp, logger)
+	if err != nil {
+		return err
+	}
+	return c.saveChallenge(req, req.ChallengeStateID, txn, sr, "", logger)
+}
+
+// saveChallenge saves the pending transaction once Okta has issued the challenge and returns an OktaChallengePending
+// error so the user is prompted for their response.
+//
+// The following errors are returned by this function:
+// OktaAuthFailure, OktaChallengePending, StateStoreFailure
+func (c *Client) saveChallenge(req *util.OpenVPNClientRequest, stateID string, txn pendingTransaction,
+	sr SecondaryAuthResponse, expiresAt string, logger zerolog.Logger) error {
+
+	if sr.Status != "MFA_CHALLENGE" {
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    AuthExceptionCode,
+			ErrorSummary: fmt.Sprintf("MFA challenge failed: status returned was '%s'", sr.Status),
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Str("status", sr.Status).Msg(e.Error())
+		return e
+	}
+
+	// remember where to resend the challenge if the user asks for it
+	for _, link := range sr.Links["resend"] {
+		if href, err := c.resolveLink(link.Href); err == nil {
+			txn.ResendLink = href
+			break
+		}
+	}
+	return c.storeChallenge(req, stateID, txn, parseExpiresAt(sr.ExpiresAt, expiresAt), logger)
+}
+
+// storeChallenge saves the pending transaction until it expires and returns an OktaChallengePending error so the
+// user is prompted for their response.
+//
+// The following errors are returned by this function:
+// OktaChallengePending, StateStoreFailure
+func (c *Client) storeChallenge(req *util.OpenVPNClientRequest, stateID string, txn pendingTransaction,
+	expires time.Time, logger zerolog.Logger) error {
+
+	if err := c.options.StateStore.Save(TransactionKeyPrefix+stateID, txn, expires); err != nil {
+		return err
+	}
+	return challengePending(req, stateID, txn, "", logger.With().Time("expires_at", expires).Logger())
+}
+
+// challengePending returns an OktaChallengePending error which prompts the user to respond to the challenge for the
+// saved transaction with the given state ID.
+//
+// If a notice is given, it is displayed before the prompt.
+//
+// The following errors are returned by this function:
+// OktaChallengePending
+func challengePending(req *util.OpenVPNClientRequest, stateID string, txn pendingTransaction, notice string,
+	logger zerolog.Logger) error {
+
+	prompt := txn.Prompt
+	if txn.ResendLink != "" {
+		prompt = fmt.Sprintf("%s (or '%s')", prompt, ResendResponse)
+	}
+	if notice != "" {
+		prompt = fmt.Sprintf("%s %s", notice, prompt)
+	}
+	e := &errors.OktaChallengePending{
+		Username:   req.Username,
+		FactorType: txn.FactorType,
+		StateID:    stateID,
+		Prompt:     prompt,
+	}
+	logger.Info().Str("state_id", e.StateID).Msg(e.Error())
+	return e
+}
+
+// verifyChallenge verifies the user's response to an MFA challenge sent during a previous connection attempt.
+//
+// If the user asks for the challenge to be resent, OktaChallengePending is returned once it has been sent again.  If
+// Okta rejects the response as invalid, OktaChallengePending is returned so the user can try again using the same
+// transaction.
+//
+// The transaction is removed once the user is authenticated or Okta fails it.  It is kept if the response is invalid
+// or Okta cannot be reached so that it can be used again until it expires.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure, OktaAccountLockedOut, OktaPasswordResetRequired, OktaRecoveryInProgress, OktaRecoveryChallenge,
+// OktaMFAEnrollRequired, OktaMFAEnrollActivate, OktaUnauthenticated, OktaMFAChallengeIncomplete
+func (c *Client) verifyChallenge(ctx context.Context, req *util.OpenVPNClientRequest, result *AuthResult) error {
+	logger := c.logger.With().
+		Str("username", req.Username).
+		Str("ip", req.ClientIP).
+		Str("location", req.Location).
+		Str("state_id", req.ChallengeStateID).
+		Logger()
+
+	// load the pending transaction
+	var txn pendingTransaction
+	key := TransactionKeyPrefix + req.ChallengeStateID
+	found, err := c.options.StateStore.Load(key, &txn)
+	if err != nil {
+		return err
+	}
+	if !found || !strings.EqualFold(txn.Username, req.Username) {
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    AuthExceptionCode,
+			ErrorSummary: "MFA challenge is unknown or has expired",
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Msg(e.Error())
+		return e
+	}
+	logger = logger.With().Str("mfa_method", txn.FactorType).Logger()
+	result.UserID = txn.UserID
+
+	// send the challenge again if the user asked for it or verify the response - transactions started using the IDX
+	// API are completed using it as well
+	response := strings.TrimSpace(req.ChallengeResponse)
+	switch {
+	case txn.APIMode == app.APIModeIDX:
+		err = c.verifyIDXChallenge(ctx, req, txn, response, result, logger)
+	case strings.EqualFold(response, ResendResponse):
+		err = c.resendChallenge(ctx, req, txn, logger)
+	default:
+		err = c.verifyChallengeResponse(ctx, req, txn, response, logger)
+	}
+
+	switch {
+	case err == nil:
+		// the transaction is complete - a failure to remove it is already logged and Okta rejects it if it is reused
+		c.options.StateStore.Delete(key)
+		return nil
+	case isInvalidPasscode(err):
+		// let the user try again without changing when the transaction expires
+		return challengePending(req, req.ChallengeStateID, txn, InvalidResponsePrompt, logger)
+	case isChallengeRetryable(err):
+		return err
+	}
+
+	// the transaction cannot be completed so cancel it
+	c.options.StateStore.Delete(key)
+	if txn.APIMode != app.APIModeIDX {
+		c.cancelTransaction(err, "", txn.StateToken, logger)
+	}
+	return err
+}
+
+// isChallengeRetryable determines whether or not the error leaves the transaction waiting for the user's response to
+// the challenge, in which case the transaction is kept so that the user can respond again.
+func isChallengeRetryable(err error) bool {
+	switch err.(type) {
+	case *errors.OktaChallengePending, *errors.OktaRequestFailure, *errors.OktaRateLimited, *errors.OktaAuthCanceled,
+		*errors.OktaProxyFailure, *errors.OktaCertificatePinFailure:
+		return true
+	}
+	return false
+}
+
+// verifyChallengeResponse verifies the user's response to an MFA challenge using the Classic authentication API.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure, OktaAccountLockedOut, OktaPasswordResetRequired, OktaRecoveryInProgress, OktaRecoveryChallenge,
+// OktaMFAEnrollRequired, OktaMFAEnrollActivate, OktaUnauthenticated, OktaMFAChallengeIncomplete
+func (c *Client) verifyChallengeResponse(ctx context.Context, req *util.OpenVPNClientRequest, txn pendingTransaction,
+	response string, logger zerolog.Logger) error {
+
+	// POST the verification request with the user's response
+	resp, err := c.postRequest(ctx, req, txn.VerifyLink, map[string]interface{}{
+		"stateToken": txn.StateToken,
+		"passCode":   response,
+	}, false)
+	if err != nil {
+		return err
+	}
+	logger = logger.With().Str("okta_request_id", oktaRequestID(resp)).Logger()
+	if logger.IsDebugEnabled() {
+		fullResponse := spew.Sdump(resp)
+		logger.Debug().Str("response", fullResponse).Msgf("MFA auth response: %s", fullResponse)
+	}
+	sr, err := c.parseSecondaryAuthResponse(req, resp, logger)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sr.Status == "SUCCESS":
+		logger.Info().Msg("MFA authentication succeeded")
+		return nil
+	case sr.Status == "MFA_CHALLENGE" && sr.FactorResult == "NEXT_PASSCODE":
+		logger.Info().Msg("token is in next token code mode; prompting user for the next code")
+		txn.Prompt = NextPasscodePrompt
+		return c.saveChallenge(req, req.ChallengeStateID, txn, sr, "", logger)
+	case sr.FactorResult == "PASSCODE_INVALID":
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    InvalidPasscodeExceptionCode,
+			ErrorSummary: "MFA passcode is invalid.",
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Str("status", sr.Status).Msg(e.Error())
+		return e
+	}
+
+	// MFA authentication failed
+	if err := transactionStatusFailure(req, sr.Status, logger); err != nil {
+		return err
+	}
+	e := &errors.OktaAuthFailure{
+		Username:     req.Username,
+		ErrorCode:    AuthExceptionCode,
+		ErrorSummary: fmt.Sprintf("MFA authentication failed: status returned was '%s'", sr.Status),
+	}
+	logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+		Str("status", sr.Status).Msg(e.Error())
+	return e
+}
+
+// defaultChallengeFactor returns the first factor type which sends a challenge that is both enabled and enrolled by
+// the user or an empty string if there is none.
+func (c *Client) defaultChallengeFactor(pr PrimaryAuthResponse) string {
+	for _, factorType := range challengeFactorTypes {
+		if (c.options.MFAMethods & challengeFactorMethods[factorType]) == 0 {
+			continue
+		}
+		for _, factor := range pr.Embedded.Factors {
+			if factor.FactorType == factorType {
+				return factorType
+			}
+		}
+	}
+	return ""
+}
+
+// newStateID generates a random ID for a pending transaction.
+func newStateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseExpiresAt returns the first valid expiration time from the given Okta timestamps or a default expiration if
+// none are valid.
+func parseExpiresAt(timestamps ...string) time.Time {
+	for _, ts := range timestamps {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			return t
+		}
+	}
+	return time.Now().Add(DefaultTransactionLifetime)
+}
@@ -0,0 +1,112 @@
+package okta
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+)
+
+func TestVerifyChallengeKeepsTransactionOnInvalidCode(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state1",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"user": map[string]interface{}{
+					"id": "00u1",
+				},
+				"factors": []interface{}{
+					map[string]interface{}{
+						"id":         "sms1",
+						"factorType": "sms",
+						"provider":   "OKTA",
+						"_links": map[string]interface{}{
+							"verify": map[string]interface{}{
+								"href": "https://example.okta.com/api/v1/authn/factors/sms1/verify",
+							},
+						},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/sms1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := decodeBody(t, r)
+		switch body["passCode"] {
+		case nil:
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status":       "MFA_CHALLENGE",
+				"factorResult": "CHALLENGE",
+			})
+		case "123456":
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status": "SUCCESS",
+			})
+		default:
+			writeJSON(w, http.StatusForbidden, map[string]interface{}{
+				"errorCode":    InvalidPasscodeExceptionCode,
+				"errorSummary": "Invalid Passcode/Answer",
+			})
+		}
+	})
+	mux.HandleFunc("/api/v1/authn/cancel", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("transaction should not be canceled")
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	})
+	client := newTestClient(t, mux, ClientOptions{
+		MFAMethods: app.MFASMS,
+	})
+
+	// the code is sent to the user
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	pending, ok := err.(*errors.OktaChallengePending)
+	if !ok {
+		t.Fatalf("expected OktaChallengePending but got %T: %v", err, err)
+	}
+
+	// a mistyped code prompts the user again using the same transaction
+	_, err = client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username:          "jdoe@example.com",
+		ChallengeStateID:  pending.StateID,
+		ChallengeResponse: "654321",
+	})
+	retry, ok := err.(*errors.OktaChallengePending)
+	if !ok {
+		t.Fatalf("expected OktaChallengePending but got %T: %v", err, err)
+	}
+	if retry.StateID != pending.StateID || !strings.HasPrefix(retry.Prompt, InvalidResponsePrompt) {
+		t.Errorf("unexpected challenge: %+v", retry)
+	}
+
+	// the correct code completes the transaction
+	result, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username:          "jdoe@example.com",
+		ChallengeStateID:  pending.StateID,
+		ChallengeResponse: "123456",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if result.UserID != "00u1" {
+		t.Errorf("expected user ID '00u1' but got '%s'", result.UserID)
+	}
+
+	// the transaction cannot be used again
+	_, err = client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username:          "jdoe@example.com",
+		ChallengeStateID:  pending.StateID,
+		ChallengeResponse: "123456",
+	})
+	if _, ok := err.(*errors.OktaAuthFailure); !ok {
+		t.Fatalf("expected OktaAuthFailure but got %T: %v", err, err)
+	}
+}
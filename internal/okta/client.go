@@ -1,8 +1,13 @@
 package okta
 
 import (
+	"context"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -11,41 +16,326 @@ import (
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	tberrors "go.innotegrity.dev/toolbox/errors"
+	"go.innotegrity.dev/zerolog"
 	"go.innotegrity.dev/zerolog/log"
 	"gopkg.in/resty.v1"
 )
 
+// Client constants.
+const (
+	// CancelTimeout is how long to wait for Okta to cancel an authentication transaction which did not succeed.
+	CancelTimeout = 10 * time.Second
+)
+
+// NumberChallengeFunc is called when Okta Verify requires the user to select the given number in the push
+// notification before it can be approved.
+type NumberChallengeFunc func(req *util.OpenVPNClientRequest, number int)
+
+// ClientOptions holds the settings used to create a new Client.
+type ClientOptions struct {
+	// AllowedGroups holds the names or IDs of the groups a user must belong to (any of them) in order to connect.
+	//
+	// If this is empty, group membership is not required.
+	AllowedGroups []string
+
+	// APIKey holds the optional Okta API key used when making requests as a trusted application.
+	APIKey string
+
+	// APIMode holds the Okta API used to authenticate users (classic, idx or oidc).
+	//
+	// If this is empty, the Classic authentication API is used.
+	APIMode string
+
+	// ClientContext holds the settings for passing details about the OpenVPN client to Okta.
+	ClientContext ClientContextOptions
+
+	// BaseURL holds the base URL of the Okta organization (eg: https://example.okta.com).
+	BaseURL string
+
+	// DeniedGroups holds the names or IDs of the groups whose members are not allowed to connect.
+	DeniedGroups []string
+
+	// FactorProviderPriority holds the order in which factor providers are tried when a user is enrolled in more than
+	// one factor which accepts the same passcode.  Providers which are not listed are tried last.
+	FactorProviderPriority []string
+
+	// HTTPClient holds the client used to make HTTP requests.
+	//
+	// If this is nil, a new client with default settings is created.
+	HTTPClient *resty.Client
+
+	// Logger holds the logger to use for all messages logged by the client.
+	//
+	// If this is nil, the global logger is used.
+	Logger *zerolog.Logger
+
+	// MFAMethods holds a bitmask for the allowed methods for MFA.
+	MFAMethods uint8
+
+	// MFATimeout holds the length of time to wait for a user to respond to an MFA request before timing out.
+	MFATimeout time.Duration
+
+	// OnNumberChallenge is called to display the number the user must select when Okta Verify requires number
+	// matching for a push request.
+	//
+	// If this is nil, the number is only logged.
+	OnNumberChallenge NumberChallengeFunc
+
+	// OnNumberChallengeResolved is called once the user approves or rejects a push request for which a number
+	// challenge was displayed so that the number is no longer shown.
+	//
+	// If this is nil, nothing is done.
+	OnNumberChallengeResolved func(req *util.OpenVPNClientRequest)
+
+	// OIDC holds the settings for the OIDC application used by the idx and oidc API modes.
+	OIDC OIDCOptions
+
+	// PasscodeFallback determines whether or not the passcode is verified against the next matching factor when a
+	// factor rejects it as invalid.
+	PasscodeFallback bool
+
+	// RememberDevice determines whether or not Okta is asked to remember the user's device once MFA succeeds when the
+	// sign-on policy allows it.
+	RememberDevice bool
+
+	// RequiredAppID holds the ID of the Okta application users must be assigned to in order to connect.
+	//
+	// If this is empty, application assignment is not checked.
+	RequiredAppID string
+
+	// Retry holds the policy for retrying requests which fail due to transient errors.
+	Retry RetryPolicy
+
+	// StateStore holds the store used to save transactions which are waiting for the user to respond to an MFA
+	// challenge (eg: an SMS code) on a subsequent connection attempt.
+	//
+	// If this is nil, a store using the default state directory is created.
+	StateStore *util.StateStore
+}
+
+// AuthResult holds information about a successfully authenticated user.
+type AuthResult struct {
+	// Claims holds the claims from the user's ID token.
+	//
+	// This is only set when the oidc API mode is used.
+	Claims map[string]interface{}
+
+	// UserID holds the Okta ID of the user.
+	UserID string
+
+	// Username holds the Okta login of the user.
+	Username string
+}
+
+// setUser saves the user's ID and login from the given user object, if present.
+func (r *AuthResult) setUser(user UserObject) {
+	if user.ID != "" {
+		r.UserID = user.ID
+	}
+	if user.Profile.Login != "" {
+		r.Username = user.Profile.Login
+	}
+}
+
+// ClientContextOptions holds the settings for passing details about the OpenVPN client to Okta.
+//
+// These details are only sent when an API key is used since Okta ignores them otherwise.
+type ClientContextOptions struct {
+	// DeviceFingerprint determines whether or not the client's device token is sent in the X-Device-Fingerprint
+	// header.
+	DeviceFingerprint bool
+
+	// Enabled determines whether or not the client's IP address and user agent are sent to Okta.
+	Enabled bool
+
+	// UserAgent holds the template for the User-Agent header.
+	//
+	// The {gui_version}, {platform} and {version} placeholders are replaced by the corresponding values sent by the
+	// OpenVPN client.  If the client sent none of them, the User-Agent header is not changed.
+	UserAgent string
+}
+
+// OIDCOptions holds the settings for the Okta OIDC application used to authenticate users.
+type OIDCOptions struct {
+	// AuthorizationServer holds the ID of the Okta authorization server (eg: default).
+	//
+	// If this is empty, the org authorization server is used.
+	AuthorizationServer string
+
+	// ClientID holds the client ID of the OIDC application.
+	ClientID string
+
+	// ClientSecret holds the optional client secret of the OIDC application.
+	ClientSecret string
+
+	// RedirectURI holds the sign-in redirect URI registered for the OIDC application.
+	RedirectURI string
+
+	// Scopes holds the OAuth 2.0 scopes to request.
+	Scopes []string
+}
+
 // Client is a client for making Okta API requests.
-type Client struct{}
+type Client struct {
+	// unexported variables
+	http        *resty.Client
+	logger      zerolog.Logger
+	mfaResponse *regexp.Regexp
+	options     ClientOptions
+}
 
 // NewClient returns a new Client object.
-func NewClient() *Client {
-	return &Client{}
+func NewClient(options ClientOptions) *Client {
+	c := &Client{
+		http:        options.HTTPClient,
+		mfaResponse: newMFAResponseRegex(options.MFAMethods),
+		options:     options,
+	}
+	if c.http == nil {
+		c.http = resty.New()
+	}
+	if c.options.StateStore == nil {
+		c.options.StateStore = util.NewStateStore(app.DefaultStateDir)
+	}
+	if options.Logger != nil {
+		c.logger = *options.Logger
+	} else {
+		c.logger = log.With().Logger()
+	}
+	return c
+}
+
+// NewClientOptions returns the options for creating a Client using the given auth settings.
+//
+// Callbacks such as OnNumberChallenge are not set and must be added by the caller if required.
+func NewClientOptions(config app.AuthOptions, logger *zerolog.Logger) ClientOptions {
+	return ClientOptions{
+		AllowedGroups: config.AllowedGroups,
+		APIKey:        config.APIKey,
+		APIMode:       config.APIMode,
+		BaseURL:       config.OrgURL,
+		ClientContext: ClientContextOptions{
+			DeviceFingerprint: config.ClientContext.DeviceFingerprint,
+			Enabled:           config.ClientContext.Enabled,
+			UserAgent:         config.ClientContext.UserAgent,
+		},
+		DeniedGroups:           config.DeniedGroups,
+		FactorProviderPriority: config.FactorProviderPriority,
+		HTTPClient: NewHTTPClient(HTTPOptions{
+			ClientCertificates: config.TLS.ClientCertificates,
+			MinTLSVersion:      config.TLS.MinVersion,
+			NoProxy:            config.HTTP.NoProxy,
+			ProxyURL:           config.HTTP.Proxy,
+			RootCAs:            config.TLS.RootCAs,
+			SPKIPins:           config.TLS.PinDigests,
+		}),
+		Logger:     logger,
+		MFAMethods: config.MFAMethods,
+		MFATimeout: config.MFATimeout,
+		OIDC: OIDCOptions{
+			AuthorizationServer: config.OIDC.AuthorizationServer,
+			ClientID:            config.OIDC.ClientID,
+			ClientSecret:        config.OIDC.ClientSecret,
+			RedirectURI:         config.OIDC.RedirectURI,
+			Scopes:              config.OIDC.Scopes,
+		},
+		PasscodeFallback: config.PasscodeFallback,
+		RememberDevice:   config.RememberDevice,
+		RequiredAppID:    config.RequiredAppID,
+		StateStore:       util.NewStateStore(config.StateDir),
+		Retry: RetryPolicy{
+			BaseBackoff:          config.Retry.BaseBackoff,
+			Jitter:               config.Retry.Jitter,
+			MaxAttempts:          config.Retry.MaxAttempts,
+			MaxBackoff:           config.Retry.MaxBackoff,
+			RetryableStatusCodes: config.Retry.RetryableStatusCodes,
+		},
+	}
 }
 
 // Authenticate attempts to authenticate the user credentials in the client request using the Okta API.
 //
+// If the context is canceled or its deadline is exceeded, any in-flight request or MFA polling is aborted.
+//
+// If an MFA challenge (eg: an SMS code) is sent to the user, OktaChallengePending is returned and the user must
+// respond to the challenge on a subsequent request.
+//
+// Once the user has been authenticated, their group memberships are checked against the allowed and denied groups and
+// their assignment to the required application is verified.
+//
+// On success, the result holds the Okta user ID and, when the oidc API mode is used, the claims from the ID token.
+//
 // The following errors are returned by this function:
-// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure
-func (c *Client) Authenticate(req *util.OpenVPNClientRequest) error {
-	config := app.Config.Auth
-	logger := log.With().
-		Str("username", req.Username).
-		Str("ip", req.ClientIP).
-		Str("location", req.Location).
-		Logger()
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// OktaTokenInvalid, StateStoreFailure, AuthorizationFailure
+func (c *Client) Authenticate(ctx context.Context, req *util.OpenVPNClientRequest) (*AuthResult, error) {
+	result := &AuthResult{
+		Username: req.Username,
+	}
+
+	// requests are only retried as long as the authentication as a whole fits within the MFA timeout
+	ctx = withRetryDeadline(ctx, c.options.MFATimeout)
+
+	var err error
+	if req.ChallengeStateID != "" {
+		// the user is responding to an MFA challenge from a previous attempt
+		err = c.verifyChallenge(ctx, req, result)
+	} else {
+		err = c.authenticate(ctx, req, result)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	// check the password and parse out TOTP if enabled
-	totp := ""
-	if (config.MFAMethods & app.MFATOTP) > 0 {
-		regex := regexp.MustCompile(`(?i)(.*?)(\+([0-9]{6}|push))?$`)
-		matches := regex.FindStringSubmatch(req.Password)
+	// make sure the user is allowed to connect
+	if err := c.authorize(ctx, req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// authenticate authenticates the user credentials using the configured API mode.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// OktaTokenInvalid, StateStoreFailure
+func (c *Client) authenticate(ctx context.Context, req *util.OpenVPNClientRequest, result *AuthResult) error {
+	// use the MFA response from the static challenge, if given, otherwise check the password and parse out the MFA
+	// response (eg: TOTP passcode or 'push') if MFA is enabled
+	mfaResponse := req.MFAResponse
+	if mfaResponse == "" && c.mfaResponse != nil {
+		matches := c.mfaResponse.FindStringSubmatch(req.Password)
 		if matches != nil {
 			req.Password = matches[1]
-			totp = matches[3]
+			mfaResponse = matches[3]
 		}
 	}
 
+	switch c.options.APIMode {
+	case app.APIModeIDX:
+		return c.authenticateIDX(ctx, req, mfaResponse, result)
+	case app.APIModeOIDC:
+		return c.authenticateOIDC(ctx, req, result)
+	}
+	return c.authenticateClassic(ctx, req, mfaResponse, result)
+}
+
+// authenticateClassic authenticates the user credentials using the Classic authentication (authn) API.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure, OktaAccountLockedOut, OktaPasswordResetRequired, OktaRecoveryInProgress, OktaRecoveryChallenge,
+// OktaMFAEnrollRequired, OktaMFAEnrollActivate, OktaUnauthenticated, OktaMFAChallengeIncomplete
+func (c *Client) authenticateClassic(ctx context.Context, req *util.OpenVPNClientRequest, mfaResponse string,
+	result *AuthResult) error {
+
+	logger := c.logger.With().
+		Str("username", req.Username).
+		Str("ip", req.ClientIP).
+		Str("location", req.Location).
+		Logger()
+
 	// perform authentication via Okta API
 	body := map[string]interface{}{
 		"username": req.Username,
@@ -54,10 +344,16 @@ func (c *Client) Authenticate(req *util.OpenVPNClientRequest) error {
 			"warnBeforePasswordExpired": true,
 		},
 	}
-	resp, err := c.postRequest(fmt.Sprintf("%s/authn", fmt.Sprintf(OktaAPIBaseURL, config.OrgName)), body)
+	if c.options.RememberDevice && req.DeviceToken != "" {
+		body["context"] = map[string]interface{}{
+			"deviceToken": req.DeviceToken,
+		}
+	}
+	resp, err := c.postRequest(ctx, req, c.apiURL("/authn"), body, true)
 	if err != nil {
 		return err
 	}
+	logger = logger.With().Str("okta_request_id", oktaRequestID(resp)).Logger()
 	if logger.IsDebugEnabled() {
 		fullResponse := spew.Sdump(resp)
 		logger.Debug().Str("response", fullResponse).Msgf("auth response: %s", fullResponse)
@@ -80,7 +376,7 @@ func (c *Client) Authenticate(req *util.OpenVPNClientRequest) error {
 			ErrorSummary: r.ErrorSummary,
 		}
 		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
-			Msg(e.Error())
+			Str("okta_error_id", r.ErrorID).Msg(e.Error())
 		return e
 	}
 
@@ -94,6 +390,30 @@ func (c *Client) Authenticate(req *util.OpenVPNClientRequest) error {
 		logger.Error().Err(e.InternalError()).Msg(e.Error())
 		return e
 	}
+	result.setUser(pr.Embedded.User)
+
+	// cancel the transaction if authentication does not succeed so that it does not linger until the state token
+	// expires
+	if err := c.processPrimaryAuthResponse(ctx, req, mfaResponse, pr, logger); err != nil {
+		link := ""
+		if cancelLink, ok := pr.Links["cancel"]; ok {
+			link = cancelLink.Href
+		}
+		c.cancelTransaction(err, link, pr.StateToken, logger)
+		return err
+	}
+	return nil
+}
+
+// processPrimaryAuthResponse completes authentication based on the status of the primary authentication response.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure, OktaAccountLockedOut, OktaPasswordResetRequired, OktaRecoveryInProgress, OktaRecoveryChallenge,
+// OktaMFAEnrollRequired, OktaMFAEnrollActivate, OktaUnauthenticated, OktaMFAChallengeIncomplete
+func (c *Client) processPrimaryAuthResponse(ctx context.Context, req *util.OpenVPNClientRequest, mfaResponse string,
+	pr PrimaryAuthResponse, logger zerolog.Logger) error {
+
 	switch pr.Status {
 	case "SUCCESS":
 		logger.Info().Msgf("authentication succeeded for '%s' (No MFA required)", req.Username)
@@ -118,12 +438,23 @@ func (c *Client) Authenticate(req *util.OpenVPNClientRequest) error {
 		logger.Info().Msg("primary authentication succeeded")
 
 		// perform MFA validation
-		if totp != "" {
-			if (config.MFAMethods&app.MFAPush) > 0 && strings.EqualFold(totp, "push") {
-				return c.validatePush(req, pr)
-			} else if (config.MFAMethods & app.MFATOTP) > 0 {
-				return c.validateTOTP(req, totp, pr)
+		switch {
+		case strings.EqualFold(mfaResponse, "push"):
+			if (c.options.MFAMethods & app.MFAPush) > 0 {
+				return c.validatePush(ctx, req, pr)
+			}
+		case mfaResponse == "":
+			// a challenge is sent using the first available factor by default if no other response was given
+			if factorType := c.defaultChallengeFactor(pr); factorType != "" {
+				return c.sendChallenge(ctx, req, factorType, pr)
+			}
+		case challengeFactorMethods[strings.ToLower(mfaResponse)] > 0:
+			factorType := strings.ToLower(mfaResponse)
+			if (c.options.MFAMethods & challengeFactorMethods[factorType]) > 0 {
+				return c.sendChallenge(ctx, req, factorType, pr)
 			}
+		default:
+			return c.validatePasscode(ctx, req, mfaResponse, pr)
 		}
 
 		// no supported MFA methods available
@@ -136,6 +467,9 @@ func (c *Client) Authenticate(req *util.OpenVPNClientRequest) error {
 			Msg(e.Error())
 		return e
 	}
+	if err := transactionStatusFailure(req, pr.Status, logger); err != nil {
+		return err
+	}
 
 	// authentication failed
 	e := &errors.OktaAuthFailure{
@@ -148,69 +482,397 @@ func (c *Client) Authenticate(req *util.OpenVPNClientRequest) error {
 	return e
 }
 
+// cancelTransaction cancels the authentication transaction with the given state token unless the error indicates that
+// the transaction is waiting for the user to respond to an MFA challenge.
+//
+// If no cancel link is given, the transaction is canceled using the standard cancel endpoint.  The request uses its
+// own context so that the transaction is canceled even if authentication was aborted.  Failures are only logged.
+func (c *Client) cancelTransaction(err error, link, stateToken string, logger zerolog.Logger) {
+	if _, ok := err.(*errors.OktaChallengePending); ok || stateToken == "" {
+		return
+	}
+	cancelURL := c.apiURL("/authn/cancel")
+	if link != "" {
+		resolved, err := c.resolveLink(link)
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to cancel Okta transaction")
+			return
+		}
+		cancelURL = resolved
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CancelTimeout)
+	defer cancel()
+	resp, err := c.postRequest(ctx, nil, cancelURL, map[string]interface{}{
+		"stateToken": stateToken,
+	}, false)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to cancel Okta transaction")
+		return
+	}
+	if resp.StatusCode() != 200 {
+		var r ErrorResponse
+		json.Unmarshal(resp.Body(), &r)
+		logger.Warn().Str("error_code", r.ErrorCode).Str("error_summary", r.ErrorSummary).
+			Msgf("failed to cancel Okta transaction: HTTP status '%s'", resp.Status())
+		return
+	}
+	logger.Debug().Msg("Okta transaction canceled")
+}
+
+// transactionStatusFailure returns the error corresponding to an authentication transaction status which prevents the
+// user from authenticating or nil if there is no specific error for the status.
+//
+// The following errors are returned by this function:
+// OktaAccountLockedOut, OktaPasswordResetRequired, OktaRecoveryInProgress, OktaRecoveryChallenge,
+// OktaMFAEnrollRequired, OktaMFAEnrollActivate, OktaUnauthenticated, OktaMFAChallengeIncomplete
+func transactionStatusFailure(req *util.OpenVPNClientRequest, status string, logger zerolog.Logger) error {
+	var e tberrors.ExtendedError
+	switch status {
+	case "LOCKED_OUT":
+		e = &errors.OktaAccountLockedOut{Username: req.Username}
+	case "PASSWORD_RESET":
+		e = &errors.OktaPasswordResetRequired{Username: req.Username}
+	case "RECOVERY":
+		e = &errors.OktaRecoveryInProgress{Username: req.Username}
+	case "RECOVERY_CHALLENGE":
+		e = &errors.OktaRecoveryChallenge{Username: req.Username}
+	case "MFA_ENROLL":
+		e = &errors.OktaMFAEnrollRequired{Username: req.Username}
+	case "MFA_ENROLL_ACTIVATE":
+		e = &errors.OktaMFAEnrollActivate{Username: req.Username}
+	case "UNAUTHENTICATED":
+		e = &errors.OktaUnauthenticated{Username: req.Username}
+	case "MFA_CHALLENGE":
+		e = &errors.OktaMFAChallengeIncomplete{Username: req.Username}
+	default:
+		return nil
+	}
+	logger.Error().Err(e.InternalError()).Str("status", status).Msg(e.Error())
+	return e
+}
+
+// apiURL returns the full URL for the given API path relative to the organization's base URL.
+func (c *Client) apiURL(path string) string {
+	return fmt.Sprintf("%s%s%s", c.options.BaseURL, APIBasePath, path)
+}
+
 // getFactorVerifyLink retrieves the verify link for the given MFA factor from the response
-func (c *Client) getFactorVerifyLink(factorType string, pr PrimaryAuthResponse) (string, error) {
+func (c *Client) getFactorVerifyLink(factorType string, pr PrimaryAuthResponse, rememberDevice bool) (string, error) {
 	for _, factor := range pr.Embedded.Factors {
 		if factor.FactorType == factorType {
-			verifyLink, ok := factor.Links["verify"]
-			if !ok {
-				return "", fmt.Errorf("'%s': MFA factor has no verification link", factorType)
-			}
-			return verifyLink.Href, nil
+			return c.getVerifyLink(factor, rememberDevice)
 		}
 	}
 	return "", fmt.Errorf("'%s': not a valid MFA factor type", factorType)
 }
 
-// postRequest performs a POST request rendering the given map to a JSON object
+// getVerifyLink retrieves the verify link for the given MFA factor
+//
+// If rememberDevice is true, the link asks Okta to remember the user's device once the factor is verified.
+func (c *Client) getVerifyLink(factor FactorObject, rememberDevice bool) (string, error) {
+	verifyLink, ok := factor.Links["verify"]
+	if !ok {
+		return "", fmt.Errorf("'%s': MFA factor has no verification link", factor.FactorType)
+	}
+	link, err := c.resolveLink(verifyLink.Href)
+	if err != nil || !rememberDevice {
+		return link, err
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("'%s': invalid link: %s", link, err.Error())
+	}
+	query := u.Query()
+	query.Set("rememberDevice", "true")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// rememberDevice determines whether or not Okta should be asked to remember the user's device once MFA succeeds so
+// that subsequent logins from the device within the policy's lifetime do not require MFA.
+func (c *Client) rememberDevice(req *util.OpenVPNClientRequest, pr PrimaryAuthResponse) bool {
+	return c.options.RememberDevice && req.DeviceToken != "" && pr.Embedded.Policy.AllowRememberDevice
+}
+
+// resolveLink rewrites a link returned by the Okta API so that it is relative to the organization's base URL.
+//
+// Okta always returns links using the organization's canonical domain, which may not be reachable (eg: when a custom
+// domain or a local mock server is in use).
+func (c *Client) resolveLink(href string) (string, error) {
+	link, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("'%s': invalid link: %s", href, err.Error())
+	}
+	base, err := url.Parse(c.options.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("'%s': invalid organization URL: %s", c.options.BaseURL, err.Error())
+	}
+	link.Scheme = base.Scheme
+	link.Host = base.Host
+	link.User = base.User
+	link.Path = base.Path + link.Path
+	link.RawPath = ""
+	return link.String(), nil
+}
+
+// parseSecondaryAuthResponse parses the response to an MFA verification request.
 //
 // The following errors are returned by this function:
-// OktaRequestFailure
-func (c *Client) postRequest(url string, body map[string]interface{}) (*resty.Response, error) {
-	config := app.Config.Auth
-	logger := log.With().
-		Str("url", url).
-		Logger()
-	if logger.IsDebugEnabled() {
-		logger = logger.With().Interface("body", body).Logger()
+// OktaResponseFailure, OktaAuthFailure
+func (c *Client) parseSecondaryAuthResponse(req *util.OpenVPNClientRequest, resp *resty.Response,
+	logger zerolog.Logger) (SecondaryAuthResponse, error) {
+
+	// error occurred
+	if resp.StatusCode() != 200 {
+		var r ErrorResponse
+		err := json.Unmarshal(resp.Body(), &r)
+		if err != nil {
+			e := &errors.OktaResponseFailure{
+				Err: err,
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return SecondaryAuthResponse{}, e
+		}
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    r.ErrorCode,
+			ErrorSummary: r.ErrorSummary,
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Str("okta_error_id", r.ErrorID).Msg(e.Error())
+		return SecondaryAuthResponse{}, e
 	}
 
+	var sr SecondaryAuthResponse
+	if err := json.Unmarshal(resp.Body(), &sr); err != nil {
+		e := &errors.OktaResponseFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return SecondaryAuthResponse{}, e
+	}
+	return sr, nil
+}
+
+// postRequest performs a POST request rendering the given map to a JSON object
+//
+// If the request is made on behalf of an OpenVPN client, details about the client are added to the request headers.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaRateLimited, OktaAuthCanceled, OktaProxyFailure, OktaCertificatePinFailure
+func (c *Client) postRequest(ctx context.Context, req *util.OpenVPNClientRequest, url string,
+	body map[string]interface{}, replayable bool) (*resty.Response, error) {
+
 	// Marshal the body into a JSON object
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 
-	// Make the request
-	request := resty.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Accept", "application/json")
-	if config.APIKey != "" {
-		request = request.SetHeader("Authorization", fmt.Sprintf("SSWS %s", config.APIKey))
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       "application/json",
 	}
-	resp, err := request.SetBody(jsonBody).Post(url)
-	if err != nil {
-		e := &errors.OktaRequestFailure{
-			Err: err,
+	if c.options.APIKey != "" {
+		headers["Authorization"] = fmt.Sprintf("SSWS %s", c.options.APIKey)
+		for name, value := range c.clientContextHeaders(req) {
+			headers[name] = value
 		}
-		logger.Error().Err(e.InternalError()).Msg(e.Error())
-		return nil, e
 	}
-	return resp, nil
+	return c.sendRequest(ctx, http.MethodPost, url, headers, jsonBody, body, replayable)
+}
+
+// clientContextHeaders returns the headers which pass the OpenVPN client's IP address, user agent and device
+// fingerprint to Okta so that network zones, behavior detection and the location shown to the user reflect the
+// client rather than the VPN server.
+func (c *Client) clientContextHeaders(req *util.OpenVPNClientRequest) map[string]string {
+	headers := map[string]string{}
+	options := c.options.ClientContext
+	if req == nil || !options.Enabled {
+		return headers
+	}
+	if req.ClientIP != "" {
+		headers["X-Forwarded-For"] = req.ClientIP
+	}
+	if options.UserAgent != "" && (req.GUIVersion != "" || req.Platform != "" || req.Version != "") {
+		headers["User-Agent"] = strings.NewReplacer(
+			"{gui_version}", req.GUIVersion,
+			"{platform}", req.Platform,
+			"{version}", req.Version,
+		).Replace(options.UserAgent)
+	}
+	if options.DeviceFingerprint && req.DeviceToken != "" {
+		headers["X-Device-Fingerprint"] = req.DeviceToken
+	}
+	return headers
+}
+
+// getRequest performs a GET request using the client's API key.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaRateLimited, OktaAuthCanceled, OktaProxyFailure, OktaCertificatePinFailure
+func (c *Client) getRequest(ctx context.Context, url string) (*resty.Response, error) {
+	headers := map[string]string{
+		"Accept": "application/json",
+	}
+	if c.options.APIKey != "" {
+		headers["Authorization"] = fmt.Sprintf("SSWS %s", c.options.APIKey)
+	}
+	return c.sendRequest(ctx, http.MethodGet, url, headers, nil, nil, true)
+}
+
+// sendRequest performs an HTTP request with the given headers and body.
+//
+// Requests which fail due to a transient error are retried according to the client's retry policy.  If replayable is
+// false, the request is only retried when it is certain that it never reached Okta (eg: the connection could not be
+// established), since sending it twice would be rejected (eg: a one-time passcode which has already been used).
+//
+// The logBody is only used for debug logging and should not contain any secrets which are not already logged.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaRateLimited, OktaAuthCanceled, OktaProxyFailure, OktaCertificatePinFailure
+func (c *Client) sendRequest(ctx context.Context, method, url string, headers map[string]string, body []byte,
+	logBody interface{}, replayable bool) (*resty.Response, error) {
+
+	logger := c.logger.With().
+		Str("method", method).
+		Str("url", url).
+		Logger()
+	if logger.IsDebugEnabled() && logBody != nil {
+		logger = logger.With().Interface("body", logBody).Logger()
+	}
+
+	// Make the request, waiting for the rate limit to reset or retrying transient failures as long as it fits within
+	// the authentication deadline
+	deadline := retryDeadline(ctx, c.options.MFATimeout)
+	policy := c.options.Retry
+	proxyURL := c.proxyURL(url)
+	for attempt := 1; ; {
+		attemptLogger := logger.With().Int("attempt", attempt).Logger()
+		attemptLogger.Debug().Msgf("sending Okta API request (attempt %d of %d)", attempt, policy.MaxAttempts)
+
+		trace := newProxyTrace(proxyURL)
+		request := c.http.R().
+			SetHeaders(headers).
+			SetContext(httptrace.WithClientTrace(ctx, trace.clientTrace()))
+		if body != nil {
+			request = request.SetBody(body)
+		}
+		resp, err := request.Execute(method, url)
+		if err != nil {
+			if ctx.Err() != nil {
+				e := &errors.OktaAuthCanceled{
+					Err: ctx.Err(),
+				}
+				attemptLogger.Error().Err(e.InternalError()).Msg(e.Error())
+				return nil, e
+			}
+			var pinErr *certificatePinError
+			if goerrors.As(err, &pinErr) {
+				e := &errors.OktaCertificatePinFailure{
+					Host: pinErr.host,
+					Err:  err,
+				}
+				attemptLogger.Error().Err(e.InternalError()).Str("host", e.Host).Msg(e.Error())
+				return nil, e
+			}
+			if attempt < policy.MaxAttempts && (replayable || isUnsentRequestError(err)) {
+				attemptLogger.Warn().Err(err).Msgf("Okta API request failed: %s", err.Error())
+				retry, waitErr := policy.waitForRetry(ctx, attemptLogger, attempt, deadline)
+				if waitErr != nil {
+					return nil, waitErr
+				}
+				if retry {
+					attempt++
+					continue
+				}
+			}
+			if proxyURL != nil && trace.isProxyFailure(err) {
+				e := &errors.OktaProxyFailure{
+					ProxyURL: proxyURL.Redacted(),
+					Err:      err,
+				}
+				attemptLogger.Error().Err(e.InternalError()).Str("proxy_url", e.ProxyURL).Msg(e.Error())
+				return nil, e
+			}
+			e := &errors.OktaRequestFailure{
+				Err: err,
+			}
+			attemptLogger.Error().Err(e.InternalError()).Msg(e.Error())
+			return nil, e
+		}
+		if proxyURL != nil && resp.StatusCode() == http.StatusProxyAuthRequired {
+			e := &errors.OktaProxyFailure{
+				ProxyURL: proxyURL.Redacted(),
+				Err:      fmt.Errorf("HTTP status '%s'", resp.Status()),
+			}
+			attemptLogger.Error().Err(e.InternalError()).Str("proxy_url", e.ProxyURL).Msg(e.Error())
+			return nil, e
+		}
+		attemptLogger = attemptLogger.With().Str("okta_request_id", oktaRequestID(resp)).Logger()
+		attemptLogger.Debug().Int("status_code", resp.StatusCode()).Msg("received Okta API response")
+
+		rl, ok := parseRateLimit(resp)
+		if ok {
+			logRateLimit(attemptLogger, rl)
+		}
+		// waiting for the rate limit to reset does not use up the attempts allowed for transient failures
+		if resp.StatusCode() == http.StatusTooManyRequests {
+			if err := waitForRateLimit(ctx, attemptLogger, rl, deadline); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if replayable && attempt < policy.MaxAttempts && policy.isRetryableStatus(resp.StatusCode()) {
+			attemptLogger.Warn().Int("status_code", resp.StatusCode()).
+				Msgf("Okta API request failed with HTTP status '%s'", resp.Status())
+			retry, err := policy.waitForRetry(ctx, attemptLogger, attempt, deadline)
+			if err != nil {
+				return nil, err
+			}
+			if retry {
+				attempt++
+				continue
+			}
+		}
+
+		// log the Okta error ID, which is shown in the Okta System Log, for failed requests
+		if resp.StatusCode() >= 400 {
+			var r ErrorResponse
+			json.Unmarshal(resp.Body(), &r)
+			attemptLogger.Warn().Int("status_code", resp.StatusCode()).Str("okta_error_id", r.ErrorID).
+				Str("error_code", r.ErrorCode).Str("error_summary", r.ErrorSummary).
+				Msgf("Okta API request failed with HTTP status '%s'", resp.Status())
+		}
+		return resp, nil
+	}
+}
+
+// resolveNumberChallenge lets the caller know that the push request is no longer waiting for the user if a number
+// challenge was displayed for it.
+func (c *Client) resolveNumberChallenge(req *util.OpenVPNClientRequest, challenge int) {
+	if challenge != 0 && c.options.OnNumberChallengeResolved != nil {
+		c.options.OnNumberChallengeResolved(req)
+	}
+}
+
+// oktaRequestID returns the ID Okta assigned to the request, which can be used to find it in the Okta System Log.
+func oktaRequestID(resp *resty.Response) string {
+	return resp.Header().Get("X-Okta-Request-Id")
 }
 
 // validatePush performs an MFA PUSH validation for the user
 //
 // The following errors are returned by this function:
-// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure
-func (c *Client) validatePush(req *util.OpenVPNClientRequest, pr PrimaryAuthResponse) error {
-	config := app.Config.Auth
-	logger := log.With().
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) validatePush(ctx context.Context, req *util.OpenVPNClientRequest, pr PrimaryAuthResponse) error {
+	logger := c.logger.With().
 		Str("mfa_method", "push").
 		Logger()
 
 	// get the verification link
-	link, err := c.getFactorVerifyLink("push", pr)
+	link, err := c.getFactorVerifyLink("push", pr, c.rememberDevice(req, pr))
 	if err != nil {
 		e := &errors.OktaRequestFailure{
 			Err: err,
@@ -220,54 +882,34 @@ func (c *Client) validatePush(req *util.OpenVPNClientRequest, pr PrimaryAuthResp
 	}
 
 	// poll until we see a response
-	timeout := int(config.MFATimeout.Seconds())
-	for i := 1; i <= timeout; i++ {
+	var challenge int
+	start := time.Now()
+	deadline := start.Add(c.options.MFATimeout)
+	for i := 1; time.Now().Before(deadline); i++ {
 		if (i % 5) == 0 {
-			logger.Info().Msgf("still waiting on MFA reply after %v seconds", i)
+			logger.Info().Msgf("still waiting on MFA reply after %v seconds", int(time.Since(start).Seconds()))
 		}
 
 		// POST the verification request
-		resp, err := c.postRequest(link, map[string]interface{}{
+		resp, err := c.postRequest(ctx, req, link, map[string]interface{}{
 			"stateToken": pr.StateToken,
-		})
+		}, true)
 		if err != nil {
 			return err
 		}
+		logger := logger.With().Str("okta_request_id", oktaRequestID(resp)).Logger()
 		if logger.IsDebugEnabled() {
 			fullResponse := spew.Sdump(resp)
 			logger.Debug().Str("response", fullResponse).Msgf("MFA auth response: %s", fullResponse)
 		}
 
-		// error occurred
-		if resp.StatusCode() != 200 {
-			var r ErrorResponse
-			err := json.Unmarshal(resp.Body(), &r)
-			if err != nil {
-				e := &errors.OktaResponseFailure{
-					Err: err,
-				}
-				logger.Error().Err(e.InternalError()).Msg(e.Error())
-				return e
-			}
-			e := &errors.OktaAuthFailure{
-				Username:     req.Username,
-				ErrorCode:    r.ErrorCode,
-				ErrorSummary: r.ErrorSummary,
-			}
-			logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
-				Msg(e.Error())
-			return e
-		}
-
 		// check the status
-		var sr SecondaryAuthResponse
-		err = json.Unmarshal(resp.Body(), &sr)
+		sr, err := c.parseSecondaryAuthResponse(req, resp, logger)
 		if err != nil {
-			e := &errors.OktaResponseFailure{
-				Err: err,
-			}
-			logger.Error().Err(e.InternalError()).Msg(e.Error())
-			return e
+			return err
+		}
+		if sr.Status != "MFA_CHALLENGE" || sr.FactorResult == "REJECTED" {
+			c.resolveNumberChallenge(req, challenge)
 		}
 		switch sr.Status {
 		case "SUCCESS":
@@ -284,7 +926,21 @@ func (c *Client) validatePush(req *util.OpenVPNClientRequest, pr PrimaryAuthResp
 					Msg(e.Error())
 				return e
 			}
+
+			// show the user the number to select if number matching is required
+			answer := sr.Embedded.Factor.Embedded.Challenge.CorrectAnswer
+			if answer != nil && *answer != challenge {
+				challenge = *answer
+				logger.Info().Int("correct_answer", challenge).
+					Msgf("Okta Verify number challenge issued; user must select %d", challenge)
+				if c.options.OnNumberChallenge != nil {
+					c.options.OnNumberChallenge(req, challenge)
+				}
+			}
 		default:
+			if err := transactionStatusFailure(req, sr.Status, logger); err != nil {
+				return err
+			}
 			e := &errors.OktaAuthFailure{
 				Username:     req.Username,
 				ErrorCode:    AuthExceptionCode,
@@ -294,95 +950,24 @@ func (c *Client) validatePush(req *util.OpenVPNClientRequest, pr PrimaryAuthResp
 				Str("status", sr.Status).Msg(e.Error())
 			return e
 		}
-		time.Sleep(time.Second)
-	}
-
-	// timed out
-	e := &errors.OktaAuthFailure{
-		Username:     req.Username,
-		ErrorCode:    AuthExceptionCode,
-		ErrorSummary: "timed out waiting for reply to PUSH request",
-	}
-	logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
-		Msg(e.Error())
-	return e
-}
-
-// validateTOTP performs an MFA TOTP validation for the user
-//
-// The following errors are returned by this function:
-// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure
-func (c *Client) validateTOTP(req *util.OpenVPNClientRequest, totp string, pr PrimaryAuthResponse) error {
-	logger := log.With().
-		Str("mfa_method", "totp").
-		Logger()
-
-	// get the verification link
-	link, err := c.getFactorVerifyLink("token:software:totp", pr)
-	if err != nil {
-		e := &errors.OktaRequestFailure{
-			Err: err,
-		}
-		logger.Error().Err(e.InternalError()).Msg(e.Error())
-		return e
-	}
-
-	// POST the verification request
-	resp, err := c.postRequest(link, map[string]interface{}{
-		"stateToken": pr.StateToken,
-	})
-	if err != nil {
-		return err
-	}
-	if logger.IsDebugEnabled() {
-		fullResponse := spew.Sdump(resp)
-		logger.Debug().Str("response", fullResponse).Msgf("MFA auth response: %s", fullResponse)
-	}
 
-	// error occurred
-	if resp.StatusCode() != 200 {
-		var r ErrorResponse
-		err := json.Unmarshal(resp.Body(), &r)
-		if err != nil {
-			e := &errors.OktaResponseFailure{
+		// wait before polling again unless the authentication is aborted
+		if err := sleep(ctx, time.Second); err != nil {
+			e := &errors.OktaAuthCanceled{
 				Err: err,
 			}
 			logger.Error().Err(e.InternalError()).Msg(e.Error())
 			return e
 		}
-		e := &errors.OktaAuthFailure{
-			Username:     req.Username,
-			ErrorCode:    r.ErrorCode,
-			ErrorSummary: r.ErrorSummary,
-		}
-		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
-			Msg(e.Error())
-		return e
-	}
-
-	// check the status
-	var sr SecondaryAuthResponse
-	err = json.Unmarshal(resp.Body(), &sr)
-	if err != nil {
-		e := &errors.OktaResponseFailure{
-			Err: err,
-		}
-		logger.Error().Err(e.InternalError()).Msg(e.Error())
-		return e
-	}
-	switch sr.Status {
-	case "SUCCESS":
-		logger.Info().Msg("MFA authentication succeeded")
-		return nil
 	}
 
-	// MFA authentication failed
+	// timed out
 	e := &errors.OktaAuthFailure{
 		Username:     req.Username,
 		ErrorCode:    AuthExceptionCode,
-		ErrorSummary: fmt.Sprintf("MFA authentication failed: status returned was '%s'", sr.Status),
+		ErrorSummary: "timed out waiting for reply to PUSH request",
 	}
 	logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
-		Str("status", sr.Status).Msg(e.Error())
+		Msg(e.Error())
 	return e
 }
@@ -0,0 +1,270 @@
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	"go.innotegrity.dev/zerolog"
+	"gopkg.in/resty.v1"
+)
+
+// newTestClient starts a test server using the given handler and returns a client which sends its requests to it.
+func newTestClient(t *testing.T, handler http.Handler, options ClientOptions) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	logger := zerolog.Nop()
+	options.BaseURL = server.URL
+	options.HTTPClient = resty.New()
+	options.Logger = &logger
+	options.StateStore = util.NewStateStore(t.TempDir())
+	if options.MFATimeout == 0 {
+		options.MFATimeout = 5 * time.Second
+	}
+	if options.Retry.MaxAttempts == 0 {
+		options.Retry.MaxAttempts = 1
+	}
+	return NewClient(options)
+}
+
+// decodeBody decodes the JSON body of the request into a map.
+func decodeBody(t *testing.T, r *http.Request) map[string]interface{} {
+	t.Helper()
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Errorf("failed to decode request body: %s", err.Error())
+	}
+	return body
+}
+
+// writeJSON writes the value to the response as JSON using the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestAuthenticateSuccess(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := decodeBody(t, r)
+		if body["username"] != "jdoe@example.com" || body["password"] != "secret" {
+			t.Errorf("unexpected credentials: %v", body)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "SUCCESS",
+			"_embedded": map[string]interface{}{
+				"user": map[string]interface{}{
+					"id": "00u1",
+					"profile": map[string]interface{}{
+						"login": "jdoe@example.com",
+					},
+				},
+			},
+		})
+	})
+	client := newTestClient(t, mux, ClientOptions{})
+
+	result, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if result.UserID != "00u1" || result.Username != "jdoe@example.com" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestAuthenticateMFATOTP(t *testing.T) {
+	verified := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		body := decodeBody(t, r)
+		if body["password"] != "secret" {
+			t.Errorf("expected the passcode to be removed from the password but got '%v'", body["password"])
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state1",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"user": map[string]interface{}{
+					"id": "00u1",
+				},
+				"factors": []interface{}{
+					map[string]interface{}{
+						"id":         "ost1",
+						"factorType": "token:software:totp",
+						"provider":   "OKTA",
+						"_links": map[string]interface{}{
+							"verify": map[string]interface{}{
+								// links use the canonical domain and must be rewritten to the test server
+								"href": "https://example.okta.com/api/v1/authn/factors/ost1/verify",
+							},
+						},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/ost1/verify", func(w http.ResponseWriter, r *http.Request) {
+		body := decodeBody(t, r)
+		if body["stateToken"] != "state1" || body["passCode"] != "123456" {
+			t.Errorf("unexpected verification request: %v", body)
+		}
+		verified = true
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "SUCCESS",
+		})
+	})
+	client := newTestClient(t, mux, ClientOptions{
+		MFAMethods: app.MFATOTP,
+	})
+
+	result, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret+123456",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !verified {
+		t.Error("TOTP passcode was not verified")
+	}
+	if result.UserID != "00u1" {
+		t.Errorf("expected user ID '00u1' but got '%s'", result.UserID)
+	}
+}
+
+func TestAuthenticateFailure(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"errorCode":    "E0000004",
+			"errorSummary": "Authentication failed",
+			"errorId":      "oae1",
+		})
+	})
+	client := newTestClient(t, mux, ClientOptions{})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "wrong",
+	})
+	e, ok := err.(*errors.OktaAuthFailure)
+	if !ok {
+		t.Fatalf("expected OktaAuthFailure but got %T: %v", err, err)
+	}
+	if e.ErrorCode != "E0000004" {
+		t.Errorf("expected error code 'E0000004' but got '%s'", e.ErrorCode)
+	}
+}
+
+func TestAuthenticatePushNumberChallenge(t *testing.T) {
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state1",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []interface{}{
+					map[string]interface{}{
+						"id":         "opf1",
+						"factorType": "push",
+						"provider":   "OKTA",
+						"_links": map[string]interface{}{
+							"verify": map[string]interface{}{
+								"href": "https://example.okta.com/api/v1/authn/factors/opf1/verify",
+							},
+						},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/opf1/verify", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls == 1 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status":       "MFA_CHALLENGE",
+				"factorResult": "WAITING",
+				"_embedded": map[string]interface{}{
+					"factor": map[string]interface{}{
+						"_embedded": map[string]interface{}{
+							"challenge": map[string]interface{}{
+								"correctAnswer": 42,
+							},
+						},
+					},
+				},
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "SUCCESS",
+		})
+	})
+	number := 0
+	resolved := false
+	client := newTestClient(t, mux, ClientOptions{
+		MFAMethods: app.MFAPush,
+		OnNumberChallenge: func(req *util.OpenVPNClientRequest, n int) {
+			number = n
+		},
+		OnNumberChallengeResolved: func(req *util.OpenVPNClientRequest) {
+			resolved = true
+		},
+	})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret+push",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if number != 42 {
+		t.Errorf("expected number challenge 42 but got %d", number)
+	}
+	if !resolved {
+		t.Error("number challenge was not resolved once the push was approved")
+	}
+}
+
+func TestAuthenticateDeviceToken(t *testing.T) {
+	for _, rememberDevice := range []bool{false, true} {
+		sent := false
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+			_, sent = decodeBody(t, r)["context"]
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"status": "SUCCESS",
+			})
+		})
+		client := newTestClient(t, mux, ClientOptions{
+			RememberDevice: rememberDevice,
+		})
+
+		_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+			DeviceToken: "0123456789abcdef0123456789abcdef",
+			Username:    "jdoe@example.com",
+			Password:    "secret",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if sent != rememberDevice {
+			t.Errorf("device token sent was %v but remember device is %v", sent, rememberDevice)
+		}
+	}
+}
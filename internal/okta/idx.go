@@ -0,0 +1,847 @@
+package okta
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	"go.innotegrity.dev/zerolog"
+	"gopkg.in/resty.v1"
+)
+
+// IDX constants.
+const (
+	// IDXBasePath is the path to the Okta Identity Engine (IDX) API.
+	IDXBasePath = "/idp/idx"
+
+	// IDXContentType is the content type of IDX requests and responses.
+	IDXContentType = "application/ion+json; okta-version=1.0.0"
+
+	// IDXDefaultPollInterval is how long to wait between polls if Okta does not specify an interval.
+	IDXDefaultPollInterval = 4 * time.Second
+
+	// IDXMaxSteps is the maximum number of remediation steps performed before giving up on the authentication.
+	IDXMaxSteps = 10
+)
+
+// idxInvalidPasscodeKeys holds the i18n keys of the IDX error messages returned when a passcode is rejected as
+// invalid.
+var idxInvalidPasscodeKeys = map[string]bool{
+	"api.authn.error.PASSCODE_INVALID": true,
+	"errors.E0000068":                  true,
+}
+
+// idxDefaultMethods holds the MFA methods tried in order when the user does not supply an MFA response.
+var idxDefaultMethods = []string{"sms", "email", "push"}
+
+// idxMethod describes how an MFA method is selected using the IDX API.
+type idxMethod struct {
+	// Flag is the MFA method flag which enables the method.
+	Flag uint8
+
+	// MethodTypes holds the IDX authenticator method types which accept it.
+	MethodTypes []string
+}
+
+// idxMethods holds the MFA methods which can be selected using the IDX API.
+var idxMethods = map[string]idxMethod{
+	"email": {app.MFAEmail, []string{"email"}},
+	"push":  {app.MFAPush, []string{"push"}},
+	"sms":   {app.MFASMS, []string{"sms"}},
+	"totp":  {app.MFATOTP, []string{"totp", "otp"}},
+}
+
+// idxAuthenticator holds information about the authenticator currently being challenged.
+type idxAuthenticator struct {
+	Value struct {
+		ContextualData struct {
+			CorrectAnswer *int `json:"correctAnswer"`
+		} `json:"contextualData"`
+		ID     string          `json:"id"`
+		Key    string          `json:"key"`
+		Poll   *idxRemediation `json:"poll"`
+		Resend *idxRemediation `json:"resend"`
+		Type   string          `json:"type"`
+	} `json:"value"`
+}
+
+// idxAuthenticatorOption is an authenticator the user may select.
+type idxAuthenticatorOption struct {
+	ID          string
+	Label       string
+	MethodTypes []string
+}
+
+// idxForm holds the fields of a remediation form.
+type idxForm struct {
+	Value []idxFormField `json:"value"`
+}
+
+// idxFormField is a field in a remediation form.
+type idxFormField struct {
+	Form     *idxForm `json:"form"`
+	Messages struct {
+		Value []idxMessage `json:"value"`
+	} `json:"messages"`
+	Name     string          `json:"name"`
+	Options  []idxOption     `json:"options"`
+	Required bool            `json:"required"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// idxMessage is a message returned by the IDX API.
+type idxMessage struct {
+	Class string `json:"class"`
+	I18n  struct {
+		Key string `json:"key"`
+	} `json:"i18n"`
+	Message string `json:"message"`
+}
+
+// idxOption is an option for a remediation form field.
+type idxOption struct {
+	Label string          `json:"label"`
+	Value json.RawMessage `json:"value"`
+}
+
+// idxRemediation describes an action which moves the authentication forward.
+type idxRemediation struct {
+	Accepts string         `json:"accepts"`
+	Href    string         `json:"href"`
+	Method  string         `json:"method"`
+	Name    string         `json:"name"`
+	Refresh int            `json:"refresh"`
+	Value   []idxFormField `json:"value"`
+}
+
+// idxResponse is the response returned by the IDX API.
+type idxResponse struct {
+	CurrentAuthenticator           idxAuthenticator `json:"currentAuthenticator"`
+	CurrentAuthenticatorEnrollment idxAuthenticator `json:"currentAuthenticatorEnrollment"`
+	ExpiresAt                      string           `json:"expiresAt"`
+	Messages                       struct {
+		Value []idxMessage `json:"value"`
+	} `json:"messages"`
+	Remediation struct {
+		Value []idxRemediation `json:"value"`
+	} `json:"remediation"`
+	StateHandle                string          `json:"stateHandle"`
+	SuccessWithInteractionCode *idxRemediation `json:"successWithInteractionCode"`
+	User                       struct {
+		Value struct {
+			ID         string `json:"id"`
+			Identifier string `json:"identifier"`
+		} `json:"value"`
+	} `json:"user"`
+}
+
+// interactResponse is the response to an OAuth 2.0 interact request.
+type interactResponse struct {
+	Error             string `json:"error"`
+	ErrorDescription  string `json:"error_description"`
+	InteractionHandle string `json:"interaction_handle"`
+}
+
+// authenticator returns the authenticator currently being challenged.
+func (r idxResponse) authenticator() idxAuthenticator {
+	if r.CurrentAuthenticatorEnrollment.Value.Type != "" {
+		return r.CurrentAuthenticatorEnrollment
+	}
+	return r.CurrentAuthenticator
+}
+
+// errorMessage returns the first error message in the response, if any.
+//
+// Errors about the values submitted in a form (eg: an invalid passcode) are returned with the form field rather than
+// with the response itself.
+func (r idxResponse) errorMessage() (idxMessage, bool) {
+	if m, ok := findErrorMessage(r.Messages.Value); ok {
+		return m, true
+	}
+	for _, rem := range r.Remediation.Value {
+		if m, ok := findFieldErrorMessage(rem.Value); ok {
+			return m, true
+		}
+	}
+	return idxMessage{}, false
+}
+
+// findErrorMessage returns the first message with the ERROR class, if any.
+func findErrorMessage(messages []idxMessage) (idxMessage, bool) {
+	for _, m := range messages {
+		if m.Class == "ERROR" {
+			return m, true
+		}
+	}
+	return idxMessage{}, false
+}
+
+// findFieldErrorMessage returns the first error message attached to the given form fields or their nested forms, if
+// any.
+func findFieldErrorMessage(fields []idxFormField) (idxMessage, bool) {
+	for _, f := range fields {
+		if m, ok := findErrorMessage(f.Messages.Value); ok {
+			return m, true
+		}
+		if f.Form != nil {
+			if m, ok := findFieldErrorMessage(f.Form.Value); ok {
+				return m, true
+			}
+		}
+	}
+	return idxMessage{}, false
+}
+
+// remediation returns the remediation with the given name, if present.
+func (r idxResponse) remediation(name string) (idxRemediation, bool) {
+	for _, rem := range r.Remediation.Value {
+		if rem.Name == name {
+			return rem, true
+		}
+	}
+	return idxRemediation{}, false
+}
+
+// remediationNames returns the names of all remediations in the response.
+func (r idxResponse) remediationNames() []string {
+	names := []string{}
+	for _, rem := range r.Remediation.Value {
+		names = append(names, rem.Name)
+	}
+	return names
+}
+
+// field returns the form field with the given name, if present.
+func (r idxRemediation) field(name string) (idxFormField, bool) {
+	for _, f := range r.Value {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return idxFormField{}, false
+}
+
+// stringValue returns the value of the field as a string or an empty string if it is not a string.
+func (f idxFormField) stringValue() string {
+	var s string
+	if err := json.Unmarshal(f.Value, &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// authenticatorOptions returns the authenticators the user may select in the remediation.
+func (r idxRemediation) authenticatorOptions() []idxAuthenticatorOption {
+	options := []idxAuthenticatorOption{}
+	field, ok := r.field("authenticator")
+	if !ok {
+		return options
+	}
+	for _, o := range field.Options {
+		var v struct {
+			Form idxForm `json:"form"`
+		}
+		if err := json.Unmarshal(o.Value, &v); err != nil {
+			continue
+		}
+		option := idxAuthenticatorOption{
+			Label: o.Label,
+		}
+		for _, f := range v.Form.Value {
+			switch f.Name {
+			case "id":
+				option.ID = f.stringValue()
+			case "methodType":
+				if s := f.stringValue(); s != "" {
+					option.MethodTypes = append(option.MethodTypes, s)
+				}
+				for _, m := range f.Options {
+					var s string
+					if err := json.Unmarshal(m.Value, &s); err == nil {
+						option.MethodTypes = append(option.MethodTypes, s)
+					}
+				}
+			}
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+// findAuthenticator returns the first authenticator option which supports one of the given method types.
+func (r idxRemediation) findAuthenticator(methodTypes ...string) (idxAuthenticatorOption, string, bool) {
+	for _, option := range r.authenticatorOptions() {
+		for _, m := range option.MethodTypes {
+			for _, methodType := range methodTypes {
+				if m == methodType {
+					return option, m, true
+				}
+			}
+		}
+	}
+	return idxAuthenticatorOption{}, "", false
+}
+
+// authenticateIDX authenticates the user credentials using the Okta Identity Engine (IDX) interaction code flow.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure
+func (c *Client) authenticateIDX(ctx context.Context, req *util.OpenVPNClientRequest, mfaResponse string,
+	result *AuthResult) error {
+
+	logger := c.logger.With().
+		Str("username", req.Username).
+		Str("ip", req.ClientIP).
+		Str("location", req.Location).
+		Str("api_mode", app.APIModeIDX).
+		Logger()
+
+	// start a new interaction
+	handle, err := c.interact(ctx, req, logger)
+	if err != nil {
+		return err
+	}
+	ir, err := c.postIDXRequest(ctx, req, fmt.Sprintf("%s%s/introspect", c.options.BaseURL, IDXBasePath),
+		map[string]interface{}{
+			"interactionHandle": handle,
+		}, true, logger)
+	if err != nil {
+		return err
+	}
+	return c.remediateIDX(ctx, req, ir, mfaResponse, false, result, logger)
+}
+
+// remediateIDX performs the remediation steps returned by Okta until the authentication succeeds or fails.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure
+func (c *Client) remediateIDX(ctx context.Context, req *util.OpenVPNClientRequest, ir idxResponse,
+	mfaResponse string, passwordSent bool, result *AuthResult, logger zerolog.Logger) error {
+
+	for step := 1; step <= IDXMaxSteps; step++ {
+		if ir.User.Value.ID != "" {
+			result.UserID = ir.User.Value.ID
+		}
+		if ir.SuccessWithInteractionCode != nil {
+			logger.Info().Msgf("authentication succeeded for '%s'", req.Username)
+			return nil
+		}
+		names := ir.remediationNames()
+		logger.Debug().Strs("remediations", names).Msgf("IDX remediation step %d", step)
+
+		var rem idxRemediation
+		var body map[string]interface{}
+		if r, ok := ir.remediation("identify"); ok {
+			// identify the user, supplying the password as well if the form accepts it
+			rem = r
+			body = map[string]interface{}{
+				"identifier": req.Username,
+			}
+			if _, ok := rem.field("credentials"); ok {
+				body["credentials"] = map[string]interface{}{
+					"passcode": req.Password,
+				}
+				passwordSent = true
+			}
+		} else if r, ok := ir.remediation("challenge-poll"); ok {
+			// wait for the user to respond to the push notification
+			next, err := c.pollIDX(ctx, req, ir, r, logger)
+			if err != nil {
+				return err
+			}
+			ir = next
+			continue
+		} else if r, ok := ir.remediation("challenge-authenticator"); ok {
+			// the password is only ever sent to the password authenticator since the policy may require MFA first
+			rem = r
+			switch ir.authenticator().Value.Type {
+			case "password":
+				body = map[string]interface{}{
+					"credentials": map[string]interface{}{
+						"passcode": req.Password,
+					},
+				}
+				passwordSent = true
+			case "phone", "email":
+				return c.saveIDXChallenge(req, ir, rem, logger)
+			default:
+				if !isPasscodeResponse(mfaResponse) || (c.options.MFAMethods&app.MFATOTP) == 0 {
+					return noSupportedMFAMethods(req, logger)
+				}
+				body = map[string]interface{}{
+					"credentials": map[string]interface{}{
+						"passcode": mfaResponse,
+					},
+				}
+			}
+		} else if r, ok := ir.remediation("select-authenticator-authenticate"); ok {
+			rem = r
+			option, methodType, err := c.selectIDXAuthenticator(req, rem, mfaResponse, passwordSent, logger)
+			if err != nil {
+				return err
+			}
+			logger.Info().Str("authenticator_id", option.ID).Str("method_type", methodType).
+				Msgf("selecting '%s' authenticator", option.Label)
+			authenticator := map[string]interface{}{
+				"id": option.ID,
+			}
+			if methodType != "password" {
+				authenticator["methodType"] = methodType
+			}
+			body = map[string]interface{}{
+				"authenticator": authenticator,
+			}
+		} else {
+			e := &errors.OktaAuthFailure{
+				Username:     req.Username,
+				ErrorCode:    AuthExceptionCode,
+				ErrorSummary: fmt.Sprintf("unsupported IDX remediation: %s", strings.Join(names, ", ")),
+			}
+			logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+				Msg(e.Error())
+			return e
+		}
+
+		// perform the remediation
+		link, err := c.resolveLink(rem.Href)
+		if err != nil {
+			e := &errors.OktaRequestFailure{
+				Err: err,
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return e
+		}
+		body["stateHandle"] = ir.StateHandle
+		next, err := c.postIDXRequest(ctx, req, link, body, false, logger.With().Str("remediation", rem.Name).Logger())
+		if err != nil {
+			return err
+		}
+		ir = next
+	}
+
+	// too many steps
+	e := &errors.OktaAuthFailure{
+		Username:     req.Username,
+		ErrorCode:    AuthExceptionCode,
+		ErrorSummary: fmt.Sprintf("authentication did not complete within %d IDX remediation steps", IDXMaxSteps),
+	}
+	logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+		Msg(e.Error())
+	return e
+}
+
+// selectIDXAuthenticator chooses the authenticator to challenge based on the MFA response supplied by the user.
+//
+// The password authenticator is always selected first if the password has not been sent yet.  If the user did not
+// supply an MFA response, the first enabled method in idxDefaultMethods offered by Okta is selected.
+//
+// The following errors are returned by this function:
+// OktaAuthFailure
+func (c *Client) selectIDXAuthenticator(req *util.OpenVPNClientRequest, rem idxRemediation, mfaResponse string,
+	passwordSent bool, logger zerolog.Logger) (idxAuthenticatorOption, string, error) {
+
+	if !passwordSent {
+		if option, methodType, ok := rem.findAuthenticator("password"); ok {
+			return option, methodType, nil
+		}
+	}
+
+	response := strings.ToLower(mfaResponse)
+	var methods []string
+	switch {
+	case response == "":
+		methods = idxDefaultMethods
+	case response == "push" || challengeFactorMethods[response] > 0:
+		methods = []string{response}
+	default:
+		methods = []string{"totp"}
+	}
+	for _, name := range methods {
+		method, ok := idxMethods[name]
+		if !ok || (c.options.MFAMethods&method.Flag) == 0 {
+			continue
+		}
+		if option, methodType, ok := rem.findAuthenticator(method.MethodTypes...); ok {
+			return option, methodType, nil
+		}
+	}
+	return idxAuthenticatorOption{}, "", noSupportedMFAMethods(req, logger)
+}
+
+// isPasscodeResponse determines whether or not the MFA response is a passcode rather than the name of a method.
+func isPasscodeResponse(mfaResponse string) bool {
+	response := strings.ToLower(mfaResponse)
+	return response != "" && response != "push" && challengeFactorMethods[response] == 0
+}
+
+// noSupportedMFAMethods logs and returns an error indicating that none of the MFA methods the user can use are
+// enabled.
+//
+// The following errors are returned by this function:
+// OktaAuthFailure
+func noSupportedMFAMethods(req *util.OpenVPNClientRequest, logger zerolog.Logger) error {
+	e := &errors.OktaAuthFailure{
+		Username:     req.Username,
+		ErrorCode:    AuthExceptionCode,
+		ErrorSummary: "MFA is required but no supported methods are available.",
+	}
+	logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+		Msg(e.Error())
+	return e
+}
+
+// pollIDX polls Okta until the user responds to a push notification and returns the next response.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) pollIDX(ctx context.Context, req *util.OpenVPNClientRequest, ir idxResponse, rem idxRemediation,
+	logger zerolog.Logger) (idxResponse, error) {
+
+	logger = logger.With().
+		Str("mfa_method", "push").
+		Logger()
+	link, err := c.resolveLink(rem.Href)
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return idxResponse{}, e
+	}
+
+	// poll until we see a response
+	var challenge int
+	start := time.Now()
+	deadline := start.Add(c.options.MFATimeout)
+	for i := 1; time.Now().Before(deadline); i++ {
+		// show the user the number to select if number matching is required
+		answer := ir.authenticator().Value.ContextualData.CorrectAnswer
+		if answer != nil && *answer != challenge {
+			challenge = *answer
+			logger.Info().Int("correct_answer", challenge).
+				Msgf("Okta Verify number challenge issued; user must select %d", challenge)
+			if c.options.OnNumberChallenge != nil {
+				c.options.OnNumberChallenge(req, challenge)
+			}
+		}
+
+		// wait before polling unless the authentication is aborted
+		interval := time.Duration(rem.Refresh) * time.Millisecond
+		if interval <= 0 {
+			interval = IDXDefaultPollInterval
+		}
+		if err := sleep(ctx, interval); err != nil {
+			e := &errors.OktaAuthCanceled{
+				Err: err,
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return idxResponse{}, e
+		}
+		if (i % 5) == 0 {
+			logger.Info().Msgf("still waiting on MFA reply after %v seconds", int(time.Since(start).Seconds()))
+		}
+
+		next, err := c.postIDXRequest(ctx, req, link, map[string]interface{}{
+			"stateHandle": ir.StateHandle,
+		}, true, logger)
+		if err != nil {
+			// Okta reports a rejected push as an error message
+			if _, ok := err.(*errors.OktaAuthFailure); ok {
+				c.resolveNumberChallenge(req, challenge)
+			}
+			return idxResponse{}, err
+		}
+		if r, ok := next.remediation("challenge-poll"); !ok {
+			c.resolveNumberChallenge(req, challenge)
+			return next, nil
+		} else if r.Refresh > 0 {
+			rem.Refresh = r.Refresh
+		}
+		ir = next
+	}
+
+	// timed out
+	e := &errors.OktaAuthFailure{
+		Username:     req.Username,
+		ErrorCode:    AuthExceptionCode,
+		ErrorSummary: "timed out waiting for reply to PUSH request",
+	}
+	logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+		Msg(e.Error())
+	return idxResponse{}, e
+}
+
+// saveIDXChallenge saves the pending IDX transaction once a code has been sent to the user and returns an
+// OktaChallengePending error so the user is prompted for their response.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaChallengePending, StateStoreFailure
+func (c *Client) saveIDXChallenge(req *util.OpenVPNClientRequest, ir idxResponse, rem idxRemediation,
+	logger zerolog.Logger) error {
+
+	factorType := "sms"
+	if ir.authenticator().Value.Type == "email" {
+		factorType = "email"
+	}
+	logger = logger.With().
+		Str("mfa_method", factorType).
+		Logger()
+
+	link, err := c.resolveLink(rem.Href)
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	stateID, err := newStateID()
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: fmt.Errorf("failed to generate transaction state ID: %s", err.Error()),
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	txn := pendingTransaction{
+		APIMode:    app.APIModeIDX,
+		FactorType: factorType,
+		Prompt:     challengePrompts[factorType],
+		StateToken: ir.StateHandle,
+		UserID:     ir.User.Value.ID,
+		Username:   req.Username,
+		VerifyLink: link,
+	}
+	if resend := ir.authenticator().Value.Resend; resend != nil {
+		if href, err := c.resolveLink(resend.Href); err == nil {
+			txn.ResendLink = href
+		}
+	}
+	return c.storeChallenge(req, stateID, txn, parseExpiresAt(ir.ExpiresAt), logger)
+}
+
+// verifyIDXChallenge verifies the user's response to an IDX challenge sent during a previous connection attempt.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure
+func (c *Client) verifyIDXChallenge(ctx context.Context, req *util.OpenVPNClientRequest, txn pendingTransaction,
+	response string, result *AuthResult, logger zerolog.Logger) error {
+
+	logger = logger.With().
+		Str("api_mode", app.APIModeIDX).
+		Logger()
+
+	// send the challenge again if the user asked for it
+	if strings.EqualFold(response, ResendResponse) {
+		if txn.ResendLink == "" {
+			e := &errors.OktaAuthFailure{
+				Username:     req.Username,
+				ErrorCode:    AuthExceptionCode,
+				ErrorSummary: fmt.Sprintf("'%s' MFA challenge cannot be resent", txn.FactorType),
+			}
+			logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+				Msg(e.Error())
+			return e
+		}
+		logger.Info().Msg("resending MFA challenge")
+		ir, err := c.postIDXRequest(ctx, req, txn.ResendLink, map[string]interface{}{
+			"stateHandle": txn.StateToken,
+		}, false, logger)
+		if err != nil {
+			return err
+		}
+		return c.storeChallenge(req, req.ChallengeStateID, txn, parseExpiresAt(ir.ExpiresAt), logger)
+	}
+
+	// answer the challenge and carry on with any remaining remediation steps
+	ir, err := c.postIDXRequest(ctx, req, txn.VerifyLink, map[string]interface{}{
+		"credentials": map[string]interface{}{
+			"passcode": response,
+		},
+		"stateHandle": txn.StateToken,
+	}, false, logger)
+	if err != nil {
+		return err
+	}
+	return c.remediateIDX(ctx, req, ir, "", true, result, logger)
+}
+
+// interact starts a new interaction code flow and returns the interaction handle.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) interact(ctx context.Context, req *util.OpenVPNClientRequest, logger zerolog.Logger) (string,
+	error) {
+
+	// PKCE parameters are required even though the interaction code is never exchanged for tokens
+	verifier, err := randomString(32)
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: fmt.Errorf("failed to generate PKCE code verifier: %s", err.Error()),
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return "", e
+	}
+	state, err := randomString(16)
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: fmt.Errorf("failed to generate OAuth state: %s", err.Error()),
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return "", e
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	form := url.Values{
+		"client_id":             {c.options.OIDC.ClientID},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"redirect_uri":          {c.options.OIDC.RedirectURI},
+		"scope":                 {strings.Join(c.options.OIDC.Scopes, " ")},
+		"state":                 {state},
+	}
+	logBody := form.Encode()
+	if c.options.OIDC.ClientSecret != "" {
+		form.Set("client_secret", c.options.OIDC.ClientSecret)
+	}
+
+	resp, err := c.sendRequest(ctx, http.MethodPost, c.oauthURL("/interact"), map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"Accept":       "application/json",
+	}, []byte(form.Encode()), logBody, true)
+	if err != nil {
+		return "", err
+	}
+	if logger.IsDebugEnabled() {
+		fullResponse := spew.Sdump(resp)
+		logger.Debug().Str("response", fullResponse).Msgf("interact response: %s", fullResponse)
+	}
+
+	var r interactResponse
+	if err := json.Unmarshal(resp.Body(), &r); err != nil {
+		e := &errors.OktaResponseFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return "", e
+	}
+	if resp.StatusCode() != 200 || r.InteractionHandle == "" {
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    r.Error,
+			ErrorSummary: r.ErrorDescription,
+		}
+		if e.ErrorCode == "" {
+			e.ErrorCode = AuthExceptionCode
+			e.ErrorSummary = fmt.Sprintf("interact request failed with HTTP status '%s'", resp.Status())
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Msg(e.Error())
+		return "", e
+	}
+	return r.InteractionHandle, nil
+}
+
+// postIDXRequest performs a POST request to the IDX API and parses the response.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) postIDXRequest(ctx context.Context, req *util.OpenVPNClientRequest, url string,
+	body map[string]interface{}, replayable bool, logger zerolog.Logger) (idxResponse, error) {
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return idxResponse{}, e
+	}
+	resp, err := c.sendRequest(ctx, http.MethodPost, url, map[string]string{
+		"Content-Type": IDXContentType,
+		"Accept":       IDXContentType,
+	}, jsonBody, body, replayable)
+	if err != nil {
+		return idxResponse{}, err
+	}
+	if logger.IsDebugEnabled() {
+		fullResponse := spew.Sdump(resp)
+		logger.Debug().Str("response", fullResponse).Msgf("IDX response: %s", fullResponse)
+	}
+	return c.parseIDXResponse(req, resp, logger)
+}
+
+// parseIDXResponse parses the response to an IDX request, returning any error messages from Okta as an error.
+//
+// The following errors are returned by this function:
+// OktaResponseFailure, OktaAuthFailure
+func (c *Client) parseIDXResponse(req *util.OpenVPNClientRequest, resp *resty.Response,
+	logger zerolog.Logger) (idxResponse, error) {
+
+	var ir idxResponse
+	if err := json.Unmarshal(resp.Body(), &ir); err != nil {
+		e := &errors.OktaResponseFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return idxResponse{}, e
+	}
+	if m, ok := ir.errorMessage(); ok {
+		// report an invalid passcode using the same error code as the Classic API so the user can try again
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    m.I18n.Key,
+			ErrorSummary: m.Message,
+		}
+		switch {
+		case idxInvalidPasscodeKeys[e.ErrorCode]:
+			e.ErrorCode = InvalidPasscodeExceptionCode
+		case e.ErrorCode == "":
+			e.ErrorCode = AuthExceptionCode
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Msg(e.Error())
+		return idxResponse{}, e
+	}
+	if resp.StatusCode() != 200 {
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    AuthExceptionCode,
+			ErrorSummary: fmt.Sprintf("IDX request failed with HTTP status '%s'", resp.Status()),
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Msg(e.Error())
+		return idxResponse{}, e
+	}
+	return ir, nil
+}
+
+// oauthURL returns the full URL for the given path on the configured authorization server.
+func (c *Client) oauthURL(path string) string {
+	if c.options.OIDC.AuthorizationServer == "" {
+		return fmt.Sprintf("%s/oauth2/v1%s", c.options.BaseURL, path)
+	}
+	return fmt.Sprintf("%s/oauth2/%s/v1%s", c.options.BaseURL, c.options.OIDC.AuthorizationServer, path)
+}
+
+// randomString returns a random URL-safe string generated from the given number of bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
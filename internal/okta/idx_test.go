@@ -0,0 +1,433 @@
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+)
+
+// idxStep is a request the fake IDX server expects along with the response it returns.
+type idxStep struct {
+	// Path is the path the request must be sent to.
+	Path string
+
+	// Passcode is the passcode the request must contain, if any.
+	Passcode string
+
+	// Response is the response returned for the request.
+	Response map[string]interface{}
+
+	// Status is the HTTP status code of the response or 0 for 200 OK.
+	Status int
+}
+
+// fakeIDXServer is a fake Okta Identity Engine which expects the given steps to be performed in order.
+type fakeIDXServer struct {
+	steps []idxStep
+	t     *testing.T
+}
+
+// ServeHTTP handles the next expected step.
+func (s *fakeIDXServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/oauth2/v1/interact" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"interaction_handle": "ih1",
+		})
+		return
+	}
+	if len(s.steps) == 0 {
+		s.t.Errorf("unexpected request to '%s'", r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	step := s.steps[0]
+	s.steps = s.steps[1:]
+	body := decodeBody(s.t, r)
+	if r.URL.Path != step.Path {
+		s.t.Errorf("expected request to '%s' but got '%s'", step.Path, r.URL.Path)
+	}
+	passcode := ""
+	if credentials, ok := body["credentials"].(map[string]interface{}); ok {
+		passcode, _ = credentials["passcode"].(string)
+	}
+	if passcode != step.Passcode {
+		s.t.Errorf("expected passcode '%s' to be sent to '%s' but got '%s'", step.Passcode, r.URL.Path, passcode)
+	}
+	status := step.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, step.Response)
+}
+
+// idxIdentifyResponse returns a response asking for the user's identifier.
+func idxIdentifyResponse() map[string]interface{} {
+	return map[string]interface{}{
+		"stateHandle": "sh1",
+		"remediation": map[string]interface{}{
+			"value": []interface{}{
+				map[string]interface{}{
+					"name": "identify",
+					"href": "https://example.okta.com/idp/idx/identify",
+					"value": []interface{}{
+						map[string]interface{}{"name": "identifier"},
+						map[string]interface{}{"name": "stateHandle"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// idxSelectResponse returns a response asking the user to select one of the given authenticators.
+func idxSelectResponse(authenticators ...map[string]interface{}) map[string]interface{} {
+	options := []interface{}{}
+	for _, a := range authenticators {
+		methodTypes := []interface{}{}
+		for _, m := range a["methodTypes"].([]string) {
+			methodTypes = append(methodTypes, map[string]interface{}{"label": m, "value": m})
+		}
+		options = append(options, map[string]interface{}{
+			"label": a["label"],
+			"value": map[string]interface{}{
+				"form": map[string]interface{}{
+					"value": []interface{}{
+						map[string]interface{}{"name": "id", "value": a["id"]},
+						map[string]interface{}{"name": "methodType", "options": methodTypes},
+					},
+				},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"stateHandle": "sh1",
+		"remediation": map[string]interface{}{
+			"value": []interface{}{
+				map[string]interface{}{
+					"name": "select-authenticator-authenticate",
+					"href": "https://example.okta.com/idp/idx/challenge",
+					"value": []interface{}{
+						map[string]interface{}{"name": "authenticator", "options": options},
+						map[string]interface{}{"name": "stateHandle"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// idxChallengeResponse returns a response asking for the answer to a challenge of the given authenticator type.
+func idxChallengeResponse(authenticatorType string) map[string]interface{} {
+	return map[string]interface{}{
+		"stateHandle": "sh1",
+		"currentAuthenticatorEnrollment": map[string]interface{}{
+			"value": map[string]interface{}{
+				"id":   "aut-" + authenticatorType,
+				"type": authenticatorType,
+			},
+		},
+		"remediation": map[string]interface{}{
+			"value": []interface{}{
+				map[string]interface{}{
+					"name": "challenge-authenticator",
+					"href": "https://example.okta.com/idp/idx/challenge/answer",
+					"value": []interface{}{
+						map[string]interface{}{"name": "credentials"},
+						map[string]interface{}{"name": "stateHandle"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// idxInvalidPasscodeResponse returns a response rejecting the passcode submitted for a challenge of the given
+// authenticator type.
+func idxInvalidPasscodeResponse(authenticatorType string) map[string]interface{} {
+	r := idxChallengeResponse(authenticatorType)
+	r["remediation"] = map[string]interface{}{
+		"value": []interface{}{
+			map[string]interface{}{
+				"name": "challenge-authenticator",
+				"href": "https://example.okta.com/idp/idx/challenge/answer",
+				"value": []interface{}{
+					map[string]interface{}{
+						"name": "credentials",
+						"form": map[string]interface{}{
+							"value": []interface{}{
+								map[string]interface{}{
+									"name": "passcode",
+									"messages": map[string]interface{}{
+										"value": []interface{}{
+											map[string]interface{}{
+												"class":   "ERROR",
+												"message": "Invalid code. Try again.",
+												"i18n": map[string]interface{}{
+													"key": "api.authn.error.PASSCODE_INVALID",
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					map[string]interface{}{"name": "stateHandle"},
+				},
+			},
+		},
+	}
+	return r
+}
+
+// idxSuccessResponse returns a response indicating that the user has been authenticated.
+func idxSuccessResponse() map[string]interface{} {
+	return map[string]interface{}{
+		"successWithInteractionCode": map[string]interface{}{
+			"name": "issue",
+			"href": "https://example.okta.com/oauth2/v1/token",
+		},
+		"user": map[string]interface{}{
+			"value": map[string]interface{}{
+				"id": "00u1",
+			},
+		},
+	}
+}
+
+// passwordAuthenticator describes the password authenticator offered by idxSelectResponse.
+var passwordAuthenticator = map[string]interface{}{
+	"id":          "aut-password",
+	"label":       "Password",
+	"methodTypes": []string{"password"},
+}
+
+// phoneAuthenticator describes the phone authenticator offered by idxSelectResponse.
+var phoneAuthenticator = map[string]interface{}{
+	"id":          "aut-phone",
+	"label":       "Phone",
+	"methodTypes": []string{"sms", "voice"},
+}
+
+// emailAuthenticator describes the email authenticator offered by idxSelectResponse.
+var emailAuthenticator = map[string]interface{}{
+	"id":          "aut-email",
+	"label":       "Email",
+	"methodTypes": []string{"email"},
+}
+
+// oktaVerifyAuthenticator describes the Okta Verify authenticator offered by idxSelectResponse.
+var oktaVerifyAuthenticator = map[string]interface{}{
+	"id":          "aut-app",
+	"label":       "Okta Verify",
+	"methodTypes": []string{"totp", "push"},
+}
+
+func TestAuthenticateIDX(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []idxStep
+	}{
+		{
+			name: "password first",
+			steps: []idxStep{
+				{Path: "/idp/idx/introspect", Response: idxIdentifyResponse()},
+				{Path: "/idp/idx/identify", Response: idxSelectResponse(passwordAuthenticator, oktaVerifyAuthenticator)},
+				{Path: "/idp/idx/challenge", Response: idxChallengeResponse("password")},
+				{Path: "/idp/idx/challenge/answer", Passcode: "secret",
+					Response: idxSelectResponse(oktaVerifyAuthenticator)},
+				{Path: "/idp/idx/challenge", Response: idxChallengeResponse("app")},
+				{Path: "/idp/idx/challenge/answer", Passcode: "123456", Response: idxSuccessResponse()},
+			},
+		},
+		{
+			name: "MFA first",
+			steps: []idxStep{
+				{Path: "/idp/idx/introspect", Response: idxIdentifyResponse()},
+				{Path: "/idp/idx/identify", Response: idxChallengeResponse("app")},
+				{Path: "/idp/idx/challenge/answer", Passcode: "123456", Response: idxChallengeResponse("password")},
+				{Path: "/idp/idx/challenge/answer", Passcode: "secret", Response: idxSuccessResponse()},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &fakeIDXServer{
+				steps: test.steps,
+				t:     t,
+			}
+			client := newTestClient(t, server, ClientOptions{
+				APIMode:    app.APIModeIDX,
+				MFAMethods: app.MFATOTP,
+				OIDC: OIDCOptions{
+					ClientID:    "client1",
+					RedirectURI: "http://localhost/callback",
+					Scopes:      []string{"openid"},
+				},
+			})
+
+			result, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+				Username: "jdoe@example.com",
+				Password: "secret+123456",
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if len(server.steps) != 0 {
+				t.Errorf("%d IDX steps were not performed", len(server.steps))
+			}
+			if result.UserID != "00u1" {
+				t.Errorf("expected user ID '00u1' but got '%s'", result.UserID)
+			}
+		})
+	}
+}
+
+func TestVerifyIDXChallengeInvalidPasscode(t *testing.T) {
+	server := &fakeIDXServer{
+		steps: []idxStep{
+			{Path: "/idp/idx/introspect", Response: idxIdentifyResponse()},
+			{Path: "/idp/idx/identify", Response: idxSelectResponse(passwordAuthenticator, phoneAuthenticator)},
+			{Path: "/idp/idx/challenge", Response: idxChallengeResponse("password")},
+			{Path: "/idp/idx/challenge/answer", Passcode: "secret", Response: idxSelectResponse(phoneAuthenticator)},
+			{Path: "/idp/idx/challenge", Response: idxChallengeResponse("phone")},
+			{Path: "/idp/idx/challenge/answer", Passcode: "654321", Response: idxInvalidPasscodeResponse("phone"),
+				Status: http.StatusBadRequest},
+			{Path: "/idp/idx/challenge/answer", Passcode: "123456", Response: idxSuccessResponse()},
+		},
+		t: t,
+	}
+	client := newTestClient(t, server, ClientOptions{
+		APIMode:    app.APIModeIDX,
+		MFAMethods: app.MFASMS,
+		OIDC: OIDCOptions{
+			ClientID:    "client1",
+			RedirectURI: "http://localhost/callback",
+			Scopes:      []string{"openid"},
+		},
+	})
+
+	// the code is sent to the user
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	pending, ok := err.(*errors.OktaChallengePending)
+	if !ok {
+		t.Fatalf("expected OktaChallengePending but got %T: %v", err, err)
+	}
+
+	// a mistyped code prompts the user again using the same transaction
+	_, err = client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username:          "jdoe@example.com",
+		ChallengeStateID:  pending.StateID,
+		ChallengeResponse: "654321",
+	})
+	retry, ok := err.(*errors.OktaChallengePending)
+	if !ok {
+		t.Fatalf("expected OktaChallengePending but got %T: %v", err, err)
+	}
+	if retry.StateID != pending.StateID || !strings.HasPrefix(retry.Prompt, InvalidResponsePrompt) {
+		t.Errorf("unexpected challenge: %+v", retry)
+	}
+
+	// the correct code completes the transaction
+	_, err = client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username:          "jdoe@example.com",
+		ChallengeStateID:  pending.StateID,
+		ChallengeResponse: "123456",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(server.steps) != 0 {
+		t.Errorf("%d IDX steps were not performed", len(server.steps))
+	}
+}
+
+func TestSelectIDXAuthenticator(t *testing.T) {
+	data, err := json.Marshal(idxSelectResponse(emailAuthenticator, oktaVerifyAuthenticator))
+	if err != nil {
+		t.Fatalf("failed to encode response: %s", err.Error())
+	}
+	var ir idxResponse
+	if err := json.Unmarshal(data, &ir); err != nil {
+		t.Fatalf("failed to decode response: %s", err.Error())
+	}
+	rem, _ := ir.remediation("select-authenticator-authenticate")
+
+	tests := []struct {
+		name       string
+		methods    uint8
+		response   string
+		methodType string
+	}{
+		{"push", app.MFAPush, "push", "push"},
+		{"push disabled", app.MFATOTP, "push", ""},
+		{"passcode", app.MFATOTP, "123456", "totp"},
+		{"passcode disabled", app.MFAPush, "123456", ""},
+		{"default email", app.MFASMS | app.MFAEmail | app.MFAPush, "", "email"},
+		{"default push", app.MFATOTP | app.MFAPush, "", "push"},
+		{"default none", app.MFATOTP, "", ""},
+		{"sms not enrolled", app.MFASMS | app.MFAPush, "sms", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, http.NotFoundHandler(), ClientOptions{
+				APIMode:    app.APIModeIDX,
+				MFAMethods: test.methods,
+			})
+			req := &util.OpenVPNClientRequest{
+				Username: "jdoe@example.com",
+			}
+
+			_, methodType, err := client.selectIDXAuthenticator(req, rem, test.response, true, client.logger)
+			if test.methodType == "" {
+				if _, ok := err.(*errors.OktaAuthFailure); !ok {
+					t.Fatalf("expected OktaAuthFailure but got %T: %v", err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if methodType != test.methodType {
+				t.Errorf("expected method type '%s' but got '%s'", test.methodType, methodType)
+			}
+		})
+	}
+}
+
+func TestAuthenticateIDXWithoutPasscode(t *testing.T) {
+	// the passcode is never sent empty when Okta challenges an authenticator which requires one
+	server := &fakeIDXServer{
+		steps: []idxStep{
+			{Path: "/idp/idx/introspect", Response: idxIdentifyResponse()},
+			{Path: "/idp/idx/identify", Response: idxChallengeResponse("app")},
+		},
+		t: t,
+	}
+	client := newTestClient(t, server, ClientOptions{
+		APIMode:    app.APIModeIDX,
+		MFAMethods: app.MFATOTP | app.MFAPush,
+		OIDC: OIDCOptions{
+			ClientID:    "client1",
+			RedirectURI: "http://localhost/callback",
+			Scopes:      []string{"openid"},
+		},
+	})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	if _, ok := err.(*errors.OktaAuthFailure); !ok {
+		t.Fatalf("expected OktaAuthFailure but got %T: %v", err, err)
+	}
+}
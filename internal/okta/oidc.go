@@ -0,0 +1,341 @@
+package okta
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	"go.innotegrity.dev/zerolog"
+)
+
+// OIDC constants.
+const (
+	// JWKSCacheLifetime is how long the signing keys of the authorization server are cached.
+	JWKSCacheLifetime = time.Hour
+
+	// JWKSKeyPrefix is the prefix for state store keys holding cached signing keys.
+	JWKSKeyPrefix = "jwks-"
+
+	// TokenClockSkew is the amount of clock skew tolerated when checking token timestamps.
+	TokenClockSkew = 2 * time.Minute
+)
+
+// jsonWebKey is a public key used to verify tokens issued by the authorization server.
+type jsonWebKey struct {
+	Alg string `json:"alg"`
+	E   string `json:"e"`
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	Use string `json:"use"`
+}
+
+// jsonWebKeySet holds the public keys of the authorization server.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// tokenResponse is the response to an OAuth 2.0 token request.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	ExpiresIn        int    `json:"expires_in"`
+	IDToken          string `json:"id_token"`
+	Scope            string `json:"scope"`
+	TokenType        string `json:"token_type"`
+}
+
+// find returns the key with the given key ID, if present.
+func (s jsonWebKeySet) find(kid string) (jsonWebKey, bool) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return jsonWebKey{}, false
+}
+
+// publicKey converts the key into an RSA public key.
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, fmt.Errorf("'%s': unsupported key type '%s'", k.Kid, k.Kty)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': invalid key modulus: %s", k.Kid, err.Error())
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': invalid key exponent: %s", k.Kid, err.Error())
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("'%s': invalid key exponent", k.Kid)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// authenticateOIDC authenticates the user credentials using the OAuth 2.0 resource owner password grant and verifies
+// the ID token returned by the authorization server.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaTokenInvalid
+func (c *Client) authenticateOIDC(ctx context.Context, req *util.OpenVPNClientRequest, result *AuthResult) error {
+	logger := c.logger.With().
+		Str("username", req.Username).
+		Str("ip", req.ClientIP).
+		Str("location", req.Location).
+		Str("api_mode", app.APIModeOIDC).
+		Logger()
+
+	// request the tokens
+	form := url.Values{
+		"grant_type": {"password"},
+		"password":   {req.Password},
+		"scope":      {strings.Join(c.options.OIDC.Scopes, " ")},
+		"username":   {req.Username},
+	}
+	headers := map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"Accept":       "application/json",
+	}
+	if c.options.OIDC.ClientSecret != "" {
+		credentials := fmt.Sprintf("%s:%s", url.QueryEscape(c.options.OIDC.ClientID),
+			url.QueryEscape(c.options.OIDC.ClientSecret))
+		headers["Authorization"] = fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(credentials)))
+	} else {
+		form.Set("client_id", c.options.OIDC.ClientID)
+	}
+	resp, err := c.sendRequest(ctx, http.MethodPost, c.oauthURL("/token"), headers, []byte(form.Encode()), nil, true)
+	if err != nil {
+		return err
+	}
+	if logger.IsDebugEnabled() {
+		fullResponse := spew.Sdump(resp)
+		logger.Debug().Str("response", fullResponse).Msgf("token response: %s", fullResponse)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(resp.Body(), &tr); err != nil {
+		e := &errors.OktaResponseFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	if resp.StatusCode() != 200 || tr.IDToken == "" {
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    tr.Error,
+			ErrorSummary: tr.ErrorDescription,
+		}
+		if e.ErrorCode == "" {
+			e.ErrorCode = AuthExceptionCode
+			e.ErrorSummary = fmt.Sprintf("token request failed with HTTP status '%s'", resp.Status())
+			if resp.StatusCode() == 200 {
+				e.ErrorSummary = "token response did not include an ID token (is the 'openid' scope requested?)"
+			}
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Msg(e.Error())
+		return e
+	}
+
+	// verify the ID token and save its claims
+	claims, err := c.verifyIDToken(ctx, req, tr.IDToken, logger)
+	if err != nil {
+		return err
+	}
+	result.Claims = claims
+	if sub, ok := claims["sub"].(string); ok {
+		result.UserID = sub
+	}
+	if username, ok := claims["preferred_username"].(string); ok && username != "" {
+		result.Username = username
+	}
+	logger.Info().Str("user_id", result.UserID).Msgf("authentication succeeded for '%s'", req.Username)
+	return nil
+}
+
+// verifyIDToken verifies the signature, issuer, audience and expiration of the ID token and returns its claims.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled, OktaTokenInvalid
+func (c *Client) verifyIDToken(ctx context.Context, req *util.OpenVPNClientRequest, token string,
+	logger zerolog.Logger) (map[string]interface{}, error) {
+
+	invalid := func(err error) error {
+		e := &errors.OktaTokenInvalid{
+			Username: req.Username,
+			Err:      err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+
+	// decode the token
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid(fmt.Errorf("token must have 3 parts but has %d", len(parts)))
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeTokenSegment(parts[0], &header); err != nil {
+		return nil, invalid(fmt.Errorf("invalid token header: %s", err.Error()))
+	}
+	claims := map[string]interface{}{}
+	if err := decodeTokenSegment(parts[1], &claims); err != nil {
+		return nil, invalid(fmt.Errorf("invalid token claims: %s", err.Error()))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid(fmt.Errorf("invalid token signature: %s", err.Error()))
+	}
+
+	// verify the signature
+	if header.Alg != "RS256" {
+		return nil, invalid(fmt.Errorf("unsupported signing algorithm '%s'", header.Alg))
+	}
+	key, err := c.getSigningKey(ctx, header.Kid, logger)
+	if err != nil {
+		// errors from this application have already been logged
+		if _, ok := err.(interface{ Code() int }); ok {
+			return nil, err
+		}
+		return nil, invalid(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, invalid(fmt.Errorf("signature verification failed: %s", err.Error()))
+	}
+
+	// check the claims
+	if iss, _ := claims["iss"].(string); iss != c.issuer() {
+		return nil, invalid(fmt.Errorf("issuer '%s' does not match '%s'", iss, c.issuer()))
+	}
+	if !hasAudience(claims["aud"], c.options.OIDC.ClientID) {
+		return nil, invalid(fmt.Errorf("audience does not include client ID '%s'", c.options.OIDC.ClientID))
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, invalid(fmt.Errorf("token has no expiration"))
+	}
+	if now.Add(-TokenClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, invalid(fmt.Errorf("token expired at %s", time.Unix(int64(exp), 0).Format(time.RFC3339)))
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(TokenClockSkew)) {
+		return nil, invalid(fmt.Errorf("token was issued in the future at %s",
+			time.Unix(int64(iat), 0).Format(time.RFC3339)))
+	}
+	return claims, nil
+}
+
+// getSigningKey returns the authorization server's public key with the given key ID.
+//
+// Keys are cached in the state store and are only fetched again once the cache expires or an unknown key ID is seen
+// (eg: after Okta rotates its keys).  Failures to read or write the cache are logged and the keys are fetched from the
+// authorization server instead.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) getSigningKey(ctx context.Context, kid string, logger zerolog.Logger) (*rsa.PublicKey, error) {
+	hash := sha256.Sum256([]byte(c.issuer()))
+	cacheKey := JWKSKeyPrefix + hex.EncodeToString(hash[:16])
+
+	var keys jsonWebKeySet
+	found, err := c.options.StateStore.Load(cacheKey, &keys)
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to read cached token signing keys; fetching keys again")
+	}
+	if found {
+		if k, ok := keys.find(kid); ok {
+			return k.publicKey()
+		}
+		logger.Info().Str("kid", kid).Msg("token signing key is not cached; fetching keys again")
+	}
+
+	// fetch the keys from the authorization server
+	resp, err := c.sendRequest(ctx, http.MethodGet, c.oauthURL("/keys"), map[string]string{
+		"Accept": "application/json",
+	}, nil, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		e := &errors.OktaResponseFailure{
+			Err: fmt.Errorf("failed to retrieve signing keys: HTTP status '%s'", resp.Status()),
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return nil, e
+	}
+	if err := json.Unmarshal(resp.Body(), &keys); err != nil {
+		e := &errors.OktaResponseFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return nil, e
+	}
+	if err := c.options.StateStore.Save(cacheKey, keys, time.Now().Add(JWKSCacheLifetime)); err != nil {
+		logger.Warn().Err(err).Msg("failed to cache token signing keys; continuing with the fetched keys")
+	}
+
+	k, ok := keys.find(kid)
+	if !ok {
+		return nil, fmt.Errorf("'%s': no such signing key", kid)
+	}
+	return k.publicKey()
+}
+
+// issuer returns the issuer identifier of the configured authorization server.
+func (c *Client) issuer() string {
+	if c.options.OIDC.AuthorizationServer == "" {
+		return c.options.BaseURL
+	}
+	return fmt.Sprintf("%s/oauth2/%s", c.options.BaseURL, c.options.OIDC.AuthorizationServer)
+}
+
+// decodeTokenSegment decodes a base64url-encoded JSON segment of a token into the given object.
+func decodeTokenSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// hasAudience determines whether or not the audience claim, which may be a string or list of strings, includes the
+// given client ID.
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
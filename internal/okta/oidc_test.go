@@ -0,0 +1,121 @@
+package okta
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+)
+
+// newOIDCTestServer returns a handler which acts as an Okta authorization server issuing ID tokens signed with the
+// given key.
+func newOIDCTestServer(t *testing.T, key *rsa.PrivateKey) http.Handler {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Errorf("failed to encode token segment: %s", err.Error())
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse token request: %s", err.Error())
+		}
+		if r.Form.Get("username") != "jdoe@example.com" || r.Form.Get("password") != "secret" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error":             "invalid_grant",
+				"error_description": "The credentials provided were invalid.",
+			})
+			return
+		}
+		now := time.Now()
+		token := encode(map[string]interface{}{"alg": "RS256", "kid": "k1"}) + "." + encode(map[string]interface{}{
+			"aud":                "client1",
+			"exp":                now.Add(time.Hour).Unix(),
+			"iat":                now.Unix(),
+			"iss":                "http://" + r.Host,
+			"preferred_username": "jdoe@example.com",
+			"sub":                "00u1",
+		})
+		digest := sha256.Sum256([]byte(token))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Errorf("failed to sign token: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id_token":   token + "." + base64.RawURLEncoding.EncodeToString(signature),
+			"token_type": "Bearer",
+		})
+	})
+	mux.HandleFunc("/oauth2/v1/keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []interface{}{
+				map[string]interface{}{
+					"alg": "RS256",
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+					"kid": "k1",
+					"kty": "RSA",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"use": "sig",
+				},
+			},
+		})
+	})
+	return mux
+}
+
+func TestAuthenticateOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err.Error())
+	}
+
+	// a state directory which cannot be created must not prevent the token from being verified
+	file := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(file, []byte{}, 0600); err != nil {
+		t.Fatalf("failed to create file: %s", err.Error())
+	}
+	stores := map[string]*util.StateStore{
+		"cached":       util.NewStateStore(t.TempDir()),
+		"cache failed": util.NewStateStore(filepath.Join(file, "state")),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			client := newTestClient(t, newOIDCTestServer(t, key), ClientOptions{
+				APIMode: app.APIModeOIDC,
+				OIDC: OIDCOptions{
+					ClientID: "client1",
+					Scopes:   []string{"openid"},
+				},
+			})
+			client.options.StateStore = store
+
+			result, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+				Username: "jdoe@example.com",
+				Password: "secret",
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if result.UserID != "00u1" || result.Claims["aud"] != "client1" {
+				t.Errorf("unexpected result: %+v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package okta
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+	"go.innotegrity.dev/zerolog"
+)
+
+// NextPasscodePrompt is the prompt displayed when an RSA SecurID token requires the next token code.
+const NextPasscodePrompt = "Wait for the code on your token to change, then enter the new code"
+
+// passcodeFactor describes an MFA factor which is verified using a passcode entered by the user.
+type passcodeFactor struct {
+	// FactorType is the Okta factor type.
+	FactorType string
+
+	// Method is the MFA method which enables the factor.
+	Method uint8
+
+	// Name is the name of the factor used in log messages.
+	Name string
+
+	// Pattern matches the passcodes accepted by the factor.
+	Pattern string
+
+	// Provider is the Okta factor provider or an empty string to match any provider.
+	Provider string
+
+	// unexported variables
+	regex *regexp.Regexp
+}
+
+// passcodeFactors holds the supported passcode factors in the order they are tried.
+var passcodeFactors = compilePasscodeFactors([]passcodeFactor{
+	{
+		FactorType: "token:software:totp",
+		Method:     app.MFATOTP,
+		Name:       "totp",
+		Pattern:    `[0-9]{6}`,
+	},
+	{
+		FactorType: "token:hardware",
+		Method:     app.MFAYubiKey,
+		Name:       "yubikey",
+		Pattern:    `[cbdefghijklnrtuv]{44}`,
+		Provider:   "YUBICO",
+	},
+	// RSA SecurID passcodes are a 6 to 8 digit tokencode, optionally preceded by a 4 to 8 character alphanumeric PIN
+	{
+		FactorType: "token",
+		Method:     app.MFARSA,
+		Name:       "rsa",
+		Pattern:    `(?:[0-9A-Za-z]{4,8})?[0-9]{6,8}`,
+		Provider:   "RSA",
+	},
+	{
+		FactorType: "token",
+		Method:     app.MFASymantec,
+		Name:       "symantec",
+		Pattern:    `[0-9]{6}`,
+		Provider:   "SYMANTEC",
+	},
+})
+
+// compilePasscodeFactors compiles the regular expression matching the passcodes accepted by each factor.
+func compilePasscodeFactors(factors []passcodeFactor) []passcodeFactor {
+	for i := range factors {
+		factors[i].regex = regexp.MustCompile(fmt.Sprintf("^(?:%s)$", factors[i].Pattern))
+	}
+	return factors
+}
+
+// matches determines whether or not the factor accepts the given passcode.
+func (f passcodeFactor) matches(passcode string) bool {
+	return f.regex.MatchString(passcode)
+}
+
+// newMFAResponseRegex returns the regular expression used to split the MFA response from the end of the password
+// or nil if no MFA methods are enabled.
+//
+// Only responses accepted by the enabled MFA methods are recognized so that passwords which happen to end with a
+// + sign followed by other characters (eg: +push when push is disabled) are left intact.
+func newMFAResponseRegex(methods uint8) *regexp.Regexp {
+	responses := []string{}
+	if (methods & app.MFAPush) > 0 {
+		responses = append(responses, "push")
+	}
+	for _, factorType := range challengeFactorTypes {
+		if (methods & challengeFactorMethods[factorType]) > 0 {
+			responses = append(responses, factorType)
+		}
+	}
+	for _, f := range passcodeFactors {
+		if (methods & f.Method) > 0 {
+			responses = append(responses, f.Pattern)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(fmt.Sprintf(`(?i)(.*?)(\+(%s))?$`, strings.Join(responses, "|")))
+}
+
+// passcodeCandidate is an enrolled factor which may be used to verify a passcode.
+type passcodeCandidate struct {
+	// Factor is the enrolled factor.
+	Factor FactorObject
+
+	// Type describes the kind of passcode factor.
+	Type passcodeFactor
+}
+
+// findPasscodeFactors returns the enabled factors the user is enrolled in which accept the given passcode.
+//
+// Factors are ordered by the client's provider priority.  Factors whose provider is not listed follow in the order
+// of the supported passcode factors and then the order in which Okta returned them.
+func (c *Client) findPasscodeFactors(passcode string, pr PrimaryAuthResponse) []passcodeCandidate {
+	candidates := []passcodeCandidate{}
+	for _, f := range passcodeFactors {
+		if (c.options.MFAMethods&f.Method) == 0 || !f.matches(passcode) {
+			continue
+		}
+		for _, factor := range pr.Embedded.Factors {
+			if factor.FactorType == f.FactorType && (f.Provider == "" || strings.EqualFold(factor.Provider, f.Provider)) {
+				candidates = append(candidates, passcodeCandidate{
+					Factor: factor,
+					Type:   f,
+				})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return c.providerRank(candidates[i].Factor.Provider) < c.providerRank(candidates[j].Factor.Provider)
+	})
+	return candidates
+}
+
+// providerRank returns the position of the provider in the client's provider priority list.
+func (c *Client) providerRank(provider string) int {
+	for i, p := range c.options.FactorProviderPriority {
+		if strings.EqualFold(p, provider) {
+			return i
+		}
+	}
+	return len(c.options.FactorProviderPriority)
+}
+
+// validatePasscode performs an MFA validation for the user using a passcode (eg: TOTP, YubiKey, RSA SecurID).
+//
+// The passcode is verified against the highest priority factor which accepts it.  If passcode fallback is enabled
+// and the factor rejects the passcode as invalid, the next matching factor is tried.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure
+func (c *Client) validatePasscode(ctx context.Context, req *util.OpenVPNClientRequest, passcode string,
+	pr PrimaryAuthResponse) error {
+
+	candidates := c.findPasscodeFactors(passcode, pr)
+	if len(candidates) == 0 {
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    AuthExceptionCode,
+			ErrorSummary: "MFA passcode does not match any supported factor enrolled by the user.",
+		}
+		c.logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Msg(e.Error())
+		return e
+	}
+
+	for i, candidate := range candidates {
+		logger := c.logger.With().
+			Str("mfa_method", candidate.Type.Name).
+			Str("factor_id", candidate.Factor.ID).
+			Str("factor_provider", candidate.Factor.Provider).
+			Int("factor_attempt", i+1).
+			Logger()
+		logger.Info().Msgf("verifying MFA passcode using factor %d of %d", i+1, len(candidates))
+
+		err := c.verifyPasscode(ctx, req, passcode, pr, candidate, logger)
+		if !isInvalidPasscode(err) || !c.options.PasscodeFallback || i == len(candidates)-1 {
+			return err
+		}
+		logger.Warn().Msg("MFA passcode was rejected; trying the next matching factor")
+	}
+	return nil
+}
+
+// verifyPasscode verifies the passcode using the given factor.
+//
+// If an RSA SecurID token is in next token code mode, OktaChallengePending is returned and the user must supply the
+// next code on a subsequent request.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaAuthFailure, OktaRateLimited, OktaAuthCanceled, OktaChallengePending,
+// StateStoreFailure
+func (c *Client) verifyPasscode(ctx context.Context, req *util.OpenVPNClientRequest, passcode string,
+	pr PrimaryAuthResponse, candidate passcodeCandidate, logger zerolog.Logger) error {
+
+	// get the verification link
+	link, err := c.getVerifyLink(candidate.Factor, c.rememberDevice(req, pr))
+	if err != nil {
+		e := &errors.OktaRequestFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+
+	// POST the verification request
+	resp, err := c.postRequest(ctx, req, link, map[string]interface{}{
+		"stateToken": pr.StateToken,
+		"passCode":   passcode,
+	}, false)
+	if err != nil {
+		return err
+	}
+	logger = logger.With().Str("okta_request_id", oktaRequestID(resp)).Logger()
+	if logger.IsDebugEnabled() {
+		fullResponse := spew.Sdump(resp)
+		logger.Debug().Str("response", fullResponse).Msgf("MFA auth response: %s", fullResponse)
+	}
+
+	// check the status
+	sr, err := c.parseSecondaryAuthResponse(req, resp, logger)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sr.Status == "SUCCESS":
+		logger.Info().Msg("MFA authentication succeeded")
+		return nil
+
+	case sr.Status == "MFA_CHALLENGE" && sr.FactorResult == "NEXT_PASSCODE":
+		// RSA SecurID requires the next token code to resynchronize the token
+		stateID, err := newStateID()
+		if err != nil {
+			e := &errors.OktaRequestFailure{
+				Err: fmt.Errorf("failed to generate transaction state ID: %s", err.Error()),
+			}
+			logger.Error().Err(e.InternalError()).Msg(e.Error())
+			return e
+		}
+		txn := pendingTransaction{
+			FactorType: candidate.Factor.FactorType,
+			Prompt:     NextPasscodePrompt,
+			StateToken: pr.StateToken,
+			UserID:     pr.Embedded.User.ID,
+			Username:   req.Username,
+			VerifyLink: link,
+		}
+		logger.Info().Msg("token is in next token code mode; prompting user for the next code")
+		return c.saveChallenge(req, stateID, txn, sr, pr.ExpiresAt, logger)
+
+	case sr.FactorResult == "PASSCODE_INVALID":
+		e := &errors.OktaAuthFailure{
+			Username:     req.Username,
+			ErrorCode:    InvalidPasscodeExceptionCode,
+			ErrorSummary: "MFA passcode is invalid.",
+		}
+		logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+			Str("status", sr.Status).Msg(e.Error())
+		return e
+	}
+
+	// MFA authentication failed
+	if err := transactionStatusFailure(req, sr.Status, logger); err != nil {
+		return err
+	}
+	e := &errors.OktaAuthFailure{
+		Username:     req.Username,
+		ErrorCode:    AuthExceptionCode,
+		ErrorSummary: fmt.Sprintf("MFA authentication failed: status returned was '%s'", sr.Status),
+	}
+	logger.Error().Err(e.InternalError()).Str("error_code", e.ErrorCode).Str("error_summary", e.ErrorSummary).
+		Str("status", sr.Status).Msg(e.Error())
+	return e
+}
+
+// isInvalidPasscode determines whether or not the error indicates that Okta rejected the passcode as invalid.
+func isInvalidPasscode(err error) bool {
+	var e *errors.OktaAuthFailure
+	return goerrors.As(err, &e) && e.ErrorCode == InvalidPasscodeExceptionCode
+}
@@ -0,0 +1,46 @@
+package okta
+
+import (
+	"testing"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+)
+
+func TestMFAResponseRegex(t *testing.T) {
+	tests := []struct {
+		name     string
+		methods  uint8
+		password string
+		expected string
+		response string
+	}{
+		{"push enabled", app.MFAPush, "secret+push", "secret", "push"},
+		{"push disabled", app.MFATOTP, "secret+push", "secret+push", ""},
+		{"sms disabled", app.MFAPush, "secret+sms", "secret+sms", ""},
+		{"sms enabled", app.MFASMS, "secret+SMS", "secret", "SMS"},
+		{"totp", app.MFATOTP | app.MFAPush, "secret+123456", "secret", "123456"},
+		{"no response", app.MFATOTP | app.MFAPush, "secret", "secret", ""},
+		{"plus in password", app.MFATOTP, "se+cret", "se+cret", ""},
+		{"rsa tokencode", app.MFARSA, "secret+12345678", "secret", "12345678"},
+		{"rsa pin and tokencode", app.MFARSA, "secret+1234123456", "secret", "1234123456"},
+		{"rsa alphanumeric pin and tokencode", app.MFARSA, "secret+Ab12cd123456", "secret", "Ab12cd123456"},
+		{"rsa alphanumeric suffix", app.MFARSA, "Pa+ssword123", "Pa+ssword123", ""},
+		{"rsa pin too long", app.MFARSA, "Pa+ssword1234x123456", "Pa+ssword1234x123456", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches := newMFAResponseRegex(test.methods).FindStringSubmatch(test.password)
+			if matches == nil {
+				t.Fatal("expected the regular expression to match")
+			}
+			if matches[1] != test.expected || matches[3] != test.response {
+				t.Errorf("expected ('%s', '%s') but got ('%s', '%s')", test.expected, test.response, matches[1],
+					matches[3])
+			}
+		})
+	}
+
+	if newMFAResponseRegex(app.MFANone) != nil {
+		t.Error("expected no regular expression when MFA is disabled")
+	}
+}
@@ -0,0 +1,127 @@
+package okta
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"go.innotegrity.dev/zerolog"
+	"gopkg.in/resty.v1"
+)
+
+// Rate limit constants.
+const (
+	// RateLimitWarnPercent is the percentage of remaining requests below which a warning is logged.
+	RateLimitWarnPercent = 10
+)
+
+// rateLimit holds the rate limit information returned in the headers of an Okta API response.
+type rateLimit struct {
+	// Limit is the maximum number of requests allowed in the current window.
+	Limit int
+
+	// Remaining is the number of requests remaining in the current window.
+	Remaining int
+
+	// Reset is the time at which the current window resets.
+	Reset time.Time
+
+	// Wait is the length of time until the current window resets based on Okta's clock.
+	Wait time.Duration
+}
+
+// parseRateLimit parses the rate limit headers from the response.
+//
+// The second return value is false if the response did not contain any rate limit information.
+func parseRateLimit(resp *resty.Response) (rateLimit, bool) {
+	headers := resp.Header()
+	limit, err := strconv.Atoi(headers.Get("X-Rate-Limit-Limit"))
+	if err != nil {
+		return rateLimit{}, false
+	}
+	rl := rateLimit{
+		Limit: limit,
+	}
+	if remaining, err := strconv.Atoi(headers.Get("X-Rate-Limit-Remaining")); err == nil {
+		rl.Remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(headers.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+		rl.Reset = time.Unix(reset, 0)
+
+		// use Okta's clock rather than ours to determine how long to wait, if possible
+		now := time.Now()
+		if date, err := http.ParseTime(headers.Get("Date")); err == nil {
+			now = date
+		}
+		rl.Wait = rl.Reset.Sub(now)
+		if rl.Wait < 0 {
+			rl.Wait = 0
+		}
+	}
+	return rl, true
+}
+
+// logRateLimit logs the remaining quota from the response, warning when it is running low.
+func logRateLimit(logger zerolog.Logger, rl rateLimit) {
+	event := logger.Debug()
+	if rl.Limit > 0 && rl.Remaining*100 < rl.Limit*RateLimitWarnPercent {
+		event = logger.Warn()
+	}
+	event.Int("rate_limit", rl.Limit).Int("rate_limit_remaining", rl.Remaining).Time("rate_limit_reset", rl.Reset).
+		Msgf("%d of %d Okta API requests remaining until %s", rl.Remaining, rl.Limit, rl.Reset.Format(time.RFC3339))
+}
+
+// waitForRateLimit waits for the rate limit window to reset so that the request can be retried.
+//
+// The wait only happens if the reset time falls before the given deadline.  Otherwise an error is returned
+// immediately.
+//
+// The following errors are returned by this function:
+// OktaRateLimited, OktaAuthCanceled
+func waitForRateLimit(ctx context.Context, logger zerolog.Logger, rl rateLimit, deadline time.Time) error {
+	// add a small buffer to account for clock skew
+	wait := rl.Wait + time.Second
+	if !time.Now().Add(wait).Before(deadline) {
+		return rateLimited(logger, rl)
+	}
+
+	logger.Warn().Int("rate_limit", rl.Limit).Time("rate_limit_reset", rl.Reset).
+		Msgf("Okta API rate limit exceeded; retrying in %v", wait)
+	if err := sleep(ctx, wait); err != nil {
+		e := &errors.OktaAuthCanceled{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return e
+	}
+	return nil
+}
+
+// rateLimited logs and returns an error indicating that the request was rejected because the rate limit was exceeded.
+//
+// The following errors are returned by this function:
+// OktaRateLimited
+func rateLimited(logger zerolog.Logger, rl rateLimit) error {
+	e := &errors.OktaRateLimited{
+		Limit:     rl.Limit,
+		Remaining: rl.Remaining,
+		Reset:     rl.Reset,
+	}
+	logger.Error().Err(e.InternalError()).Int("rate_limit", e.Limit).Time("rate_limit_reset", e.Reset).
+		Msg(e.Error())
+	return e
+}
+
+// sleep pauses for the given duration, returning early with the context's error if the context is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package okta
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+)
+
+// newRateLimitTestServer returns a handler which rejects the given number of requests because the rate limit was
+// exceeded before authenticating the user.
+//
+// The rate limit resets immediately so each retry only waits for the clock skew buffer.
+func newRateLimitTestServer(limited int, requests *int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		w.Header().Set("X-Rate-Limit-Limit", "10")
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Unix(), 10))
+		if *requests <= limited {
+			w.Header().Set("X-Rate-Limit-Remaining", "0")
+			writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+				"errorCode":    "E0000047",
+				"errorSummary": "API call exceeded rate limit due to too many requests.",
+			})
+			return
+		}
+		w.Header().Set("X-Rate-Limit-Remaining", "9")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "SUCCESS",
+		})
+	})
+	return mux
+}
+
+func TestRateLimitedRequestsDoNotUseRetryAttempts(t *testing.T) {
+	requests := 0
+	client := newTestClient(t, newRateLimitTestServer(1, &requests), ClientOptions{
+		MFATimeout: time.Minute,
+		Retry: RetryPolicy{
+			MaxAttempts: 1,
+		},
+	})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests but got %d", requests)
+	}
+}
+
+func TestRateLimitedRequestsStopAtDeadline(t *testing.T) {
+	requests := 0
+	client := newTestClient(t, newRateLimitTestServer(10, &requests), ClientOptions{
+		MFATimeout: 1500 * time.Millisecond,
+		Retry: RetryPolicy{
+			MaxAttempts: 5,
+		},
+	})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	if _, ok := err.(*errors.OktaRateLimited); !ok {
+		t.Fatalf("expected OktaRateLimited but got %T: %v", err, err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests but got %d", requests)
+	}
+}
+
+func TestRetryDeadlineIsSharedAcrossRequests(t *testing.T) {
+	ctx := withRetryDeadline(context.Background(), time.Minute)
+	deadline := retryDeadline(ctx, time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if again := retryDeadline(withRetryDeadline(ctx, time.Minute), time.Minute); !again.Equal(deadline) {
+		t.Errorf("expected deadline %s but got %s", deadline, again)
+	}
+
+	// a deadline set on the context itself takes precedence
+	expected := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(ctx, expected)
+	defer cancel()
+	if d := retryDeadline(ctx, time.Minute); !d.Equal(expected) {
+		t.Errorf("expected deadline %s but got %s", expected, d)
+	}
+}
@@ -0,0 +1,124 @@
+package okta
+
+import (
+	"context"
+	goerrors "errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"go.innotegrity.dev/zerolog"
+)
+
+// retryDeadlineKey is the context key holding the time after which failed requests are no longer retried.
+type retryDeadlineKey struct{}
+
+// RetryPolicy determines how failed Okta API requests are retried.
+type RetryPolicy struct {
+	// BaseBackoff is the length of time to wait before the first retry.  The wait doubles for each subsequent retry.
+	BaseBackoff time.Duration
+
+	// Jitter is the fraction (0-1) of each wait which is randomized to avoid retrying in lockstep with other clients.
+	Jitter float64
+
+	// MaxAttempts is the maximum number of times a request is attempted, including the first attempt.
+	MaxAttempts int
+
+	// MaxBackoff is the maximum length of time to wait between attempts.
+	MaxBackoff time.Duration
+
+	// RetryableStatusCodes holds the HTTP status codes which indicate a transient failure.
+	RetryableStatusCodes []int
+}
+
+// backoff returns how long to wait after the given failed attempt before trying again.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.BaseBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(rand.Float64() * p.Jitter * float64(wait))
+	}
+	return wait
+}
+
+// isRetryableStatus determines whether or not the given HTTP status code indicates a transient failure.
+func (p RetryPolicy) isRetryableStatus(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDeadline returns the time after which failed requests made using the given context are no longer retried.
+//
+// The context's own deadline takes precedence over one set using withRetryDeadline.  If neither is set, the deadline
+// is the given timeout from now.
+func retryDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	if deadline, ok := ctx.Value(retryDeadlineKey{}).(time.Time); ok {
+		return deadline
+	}
+	return time.Now().Add(timeout)
+}
+
+// withRetryDeadline returns a context which stops requests from being retried once the given timeout from now has
+// passed so that every request made during an authentication shares the same deadline.
+//
+// If the context already has a deadline, it is returned unchanged.
+func withRetryDeadline(ctx context.Context, timeout time.Duration) context.Context {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx
+	}
+	if _, ok := ctx.Value(retryDeadlineKey{}).(time.Time); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, retryDeadlineKey{}, time.Now().Add(timeout))
+}
+
+// isUnsentRequestError determines whether or not the error guarantees the request never reached the server, in
+// which case it is always safe to send it again.
+func isUnsentRequestError(err error) bool {
+	var dnsErr *net.DNSError
+	if goerrors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	if goerrors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+// waitForRetry waits before the next attempt of a failed request.
+//
+// If the next attempt cannot be made before the given deadline, false is returned immediately.
+//
+// The following errors are returned by this function:
+// OktaAuthCanceled
+func (p RetryPolicy) waitForRetry(ctx context.Context, logger zerolog.Logger, attempt int,
+	deadline time.Time) (bool, error) {
+
+	wait := p.backoff(attempt)
+	if !time.Now().Add(wait).Before(deadline) {
+		return false, nil
+	}
+	logger.Warn().Msgf("Okta API request attempt %d of %d failed; retrying in %v", attempt, p.MaxAttempts, wait)
+	if err := sleep(ctx, wait); err != nil {
+		e := &errors.OktaAuthCanceled{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return false, e
+	}
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package okta
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/util"
+)
+
+// testRetryPolicy is a retry policy which does not slow down the tests.
+var testRetryPolicy = RetryPolicy{
+	BaseBackoff:          time.Millisecond,
+	MaxAttempts:          3,
+	MaxBackoff:           5 * time.Millisecond,
+	RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+}
+
+func TestRetryTransientFailure(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "SUCCESS",
+		})
+	})
+	client := newTestClient(t, mux, ClientOptions{
+		Retry: testRetryPolicy,
+	})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests but got %d", requests)
+	}
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"errorCode":    "E0000009",
+			"errorSummary": "Internal Server Error",
+		})
+	})
+	client := newTestClient(t, mux, ClientOptions{
+		Retry: testRetryPolicy,
+	})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret",
+	})
+	if _, ok := err.(*errors.OktaAuthFailure); !ok {
+		t.Fatalf("expected OktaAuthFailure but got %T: %v", err, err)
+	}
+	if requests != testRetryPolicy.MaxAttempts {
+		t.Errorf("expected %d requests but got %d", testRetryPolicy.MaxAttempts, requests)
+	}
+}
+
+func TestRetrySkipsPasscodeVerification(t *testing.T) {
+	verifications := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"stateToken": "state1",
+			"status":     "MFA_REQUIRED",
+			"_embedded": map[string]interface{}{
+				"factors": []interface{}{
+					map[string]interface{}{
+						"id":         "ost1",
+						"factorType": "token:software:totp",
+						"provider":   "OKTA",
+						"_links": map[string]interface{}{
+							"verify": map[string]interface{}{
+								"href": "https://example.okta.com/api/v1/authn/factors/ost1/verify",
+							},
+						},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/factors/ost1/verify", func(w http.ResponseWriter, r *http.Request) {
+		verifications++
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"errorCode":    "E0000009",
+			"errorSummary": "Internal Server Error",
+		})
+	})
+	mux.HandleFunc("/api/v1/authn/cancel", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	})
+	client := newTestClient(t, mux, ClientOptions{
+		MFAMethods: app.MFATOTP,
+		Retry:      testRetryPolicy,
+	})
+
+	_, err := client.Authenticate(context.Background(), &util.OpenVPNClientRequest{
+		Username: "jdoe@example.com",
+		Password: "secret+123456",
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if verifications != 1 {
+		t.Errorf("expected the one-time passcode to be sent once but it was sent %d times", verifications)
+	}
+}
@@ -0,0 +1,171 @@
+package okta
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	goerrors "errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/http/httpproxy"
+	"gopkg.in/resty.v1"
+)
+
+// HTTPOptions holds the settings used to create the HTTP client for making Okta API requests.
+type HTTPOptions struct {
+	// ClientCertificates holds the certificates presented when a server (eg: a proxy) requests a client certificate.
+	ClientCertificates []tls.Certificate
+
+	// MinTLSVersion holds the minimum TLS version (eg: tls.VersionTLS12).
+	MinTLSVersion uint16
+
+	// NoProxy holds the hosts, domains, IP addresses and networks which are reached without using the proxy.
+	NoProxy []string
+
+	// ProxyURL holds the URL of the HTTP proxy, including any credentials.
+	//
+	// If this is nil, the proxy is taken from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables.
+	ProxyURL *url.URL
+
+	// RootCAs holds the CA certificates trusted when verifying server certificates.
+	//
+	// If this is nil, the system CA certificates are trusted.
+	RootCAs *x509.CertPool
+
+	// SPKIPins holds the SHA-256 digests of the subject public keys, at least one of which must be present in the
+	// verified certificate chain of every connection.
+	//
+	// This includes the TLS connection to an https:// proxy set in ProxyURL.  If this is empty, certificates are not
+	// pinned.
+	SPKIPins [][]byte
+}
+
+// NewHTTPClient creates a new HTTP client for making Okta API requests using the given settings.
+func NewHTTPClient(options HTTPOptions) *resty.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		Certificates: options.ClientCertificates,
+		MinVersion:   options.MinTLSVersion,
+		RootCAs:      options.RootCAs,
+	}
+	if len(options.SPKIPins) > 0 {
+		transport.TLSClientConfig.VerifyConnection = verifyPins(options)
+	}
+	if options.ProxyURL != nil {
+		proxyFunc := (&httpproxy.Config{
+			HTTPProxy:  options.ProxyURL.String(),
+			HTTPSProxy: options.ProxyURL.String(),
+			NoProxy:    strings.Join(options.NoProxy, ","),
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+	return resty.New().SetTransport(transport)
+}
+
+// certificatePinError occurs when none of the certificates presented by a server match the configured pins.
+type certificatePinError struct {
+	host string
+}
+
+// Error returns the string version of the error.
+func (e *certificatePinError) Error() string {
+	return fmt.Sprintf("no certificate presented by '%s' matches the configured SPKI pins", e.host)
+}
+
+// verifyPins returns a function which fails the TLS handshake unless a certificate in one of the server's verified
+// chains has a subject public key matching one of the pins.
+//
+// Certificates presented by the server which are not part of a verified chain are ignored since the server could
+// include any certificate, such as Okta's, alongside its own.
+func verifyPins(options HTTPOptions) func(tls.ConnectionState) error {
+	pins := map[string]bool{}
+	for _, pin := range options.SPKIPins {
+		pins[string(pin)] = true
+	}
+
+	return func(cs tls.ConnectionState) error {
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[string(digest[:])] {
+					return nil
+				}
+			}
+		}
+		return &certificatePinError{
+			host: cs.ServerName,
+		}
+	}
+}
+
+// proxyTrace tracks whether or not a connection to Okta was established through the proxy so that proxy failures can
+// be told apart from Okta failures.
+type proxyTrace struct {
+	// proxyHandshakes is the number of TLS handshakes performed with the proxy itself before the tunnel is opened.
+	proxyHandshakes int32
+
+	// handshakes is the number of TLS handshakes started so far.
+	handshakes int32
+
+	// tunneled is set once the proxy has opened the tunnel to Okta.
+	tunneled int32
+}
+
+// newProxyTrace creates a new proxyTrace object for a request sent through the given proxy.
+func newProxyTrace(proxyURL *url.URL) *proxyTrace {
+	t := &proxyTrace{}
+	if proxyURL != nil && proxyURL.Scheme == "https" {
+		t.proxyHandshakes = 1
+	}
+	return t
+}
+
+// clientTrace returns the hooks which record when the tunnel to Okta is established.
+func (t *proxyTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		// the TLS handshake with an https:// proxy comes first and the handshake with Okta only starts once the
+		// proxy has accepted the CONNECT request
+		TLSHandshakeStart: func() {
+			if atomic.AddInt32(&t.handshakes, 1) > t.proxyHandshakes {
+				atomic.StoreInt32(&t.tunneled, 1)
+			}
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			atomic.StoreInt32(&t.tunneled, 1)
+		},
+	}
+}
+
+// isProxyFailure determines whether or not the error occurred while connecting to or through the proxy.
+func (t *proxyTrace) isProxyFailure(err error) bool {
+	var opErr *net.OpError
+	if goerrors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return true
+	}
+	return atomic.LoadInt32(&t.tunneled) == 0
+}
+
+// proxyURL returns the URL of the proxy used for requests to the given URL or nil if no proxy is used.
+func (c *Client) proxyURL(rawURL string) *url.URL {
+	transport, ok := c.http.GetClient().Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil
+	}
+	proxyURL, err := transport.Proxy(req)
+	if err != nil {
+		return nil
+	}
+	return proxyURL
+}
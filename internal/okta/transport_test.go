@@ -0,0 +1,79 @@
+package okta
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	goerrors "errors"
+	"net/url"
+	"testing"
+)
+
+func TestProxyTraceIsProxyFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxyURL   string
+		handshakes int
+		expected   bool
+	}{
+		{"http proxy before tunnel", "http://proxy.example.com:3128", 0, true},
+		{"http proxy Okta handshake", "http://proxy.example.com:3128", 1, false},
+		{"https proxy handshake", "https://proxy.example.com:3128", 1, true},
+		{"https proxy Okta handshake", "https://proxy.example.com:3128", 2, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxyURL, err := url.Parse(test.proxyURL)
+			if err != nil {
+				t.Fatalf("failed to parse proxy URL: %s", err.Error())
+			}
+			trace := newProxyTrace(proxyURL)
+			hooks := trace.clientTrace()
+			for i := 0; i < test.handshakes; i++ {
+				hooks.TLSHandshakeStart()
+			}
+			if actual := trace.isProxyFailure(goerrors.New("remote error: tls: handshake failure")); actual != test.expected {
+				t.Errorf("expected proxy failure to be %v but got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestVerifyPins(t *testing.T) {
+	okta := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("okta")}
+	other := &x509.Certificate{RawSubjectPublicKeyInfo: []byte("other")}
+	pin := sha256.Sum256(okta.RawSubjectPublicKeyInfo)
+	verify := verifyPins(HTTPOptions{
+		SPKIPins: [][]byte{pin[:]},
+	})
+
+	tests := []struct {
+		name    string
+		state   tls.ConnectionState
+		success bool
+	}{
+		{"pinned key in verified chain", tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{okta},
+			VerifiedChains:   [][]*x509.Certificate{{okta}},
+		}, true},
+		{"pinned key only presented by server", tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{other, okta},
+			VerifiedChains:   [][]*x509.Certificate{{other}},
+		}, false},
+		{"no verified chains", tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{okta},
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.state.ServerName = "example.okta.com"
+			err := verify(test.state)
+			if test.success && err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if _, ok := err.(*certificatePinError); !test.success && !ok {
+				t.Fatalf("expected certificatePinError but got %T: %v", err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+)
+
+// GetUser retrieves the user with the given ID or login.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) GetUser(ctx context.Context, idOrLogin string) (UserObject, error) {
+	logger := c.logger.With().
+		Str("username", idOrLogin).
+		Logger()
+
+	resp, err := c.getRequest(ctx, c.apiURL(fmt.Sprintf("/users/%s", url.PathEscape(idOrLogin))))
+	if err != nil {
+		return UserObject{}, err
+	}
+	if resp.StatusCode() != 200 {
+		var r ErrorResponse
+		json.Unmarshal(resp.Body(), &r)
+		e := &errors.OktaResponseFailure{
+			Err: fmt.Errorf("failed to retrieve user: HTTP status '%s': %s (%s)", resp.Status(), r.ErrorSummary,
+				r.ErrorCode),
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return UserObject{}, e
+	}
+	var user UserObject
+	if err := json.Unmarshal(resp.Body(), &user); err != nil {
+		e := &errors.OktaResponseFailure{
+			Err: err,
+		}
+		logger.Error().Err(e.InternalError()).Msg(e.Error())
+		return UserObject{}, e
+	}
+	return user, nil
+}
+
+// GetUserGroups retrieves all of the groups the user with the given ID belongs to.
+//
+// The following errors are returned by this function:
+// OktaRequestFailure, OktaResponseFailure, OktaRateLimited, OktaAuthCanceled
+func (c *Client) GetUserGroups(ctx context.Context, userID string) ([]GroupObject, error) {
+	logger := c.logger.With().
+		Str("user_id", userID).
+		Logger()
+	return c.getUserGroups(ctx, userID, logger)
+}
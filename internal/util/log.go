@@ -0,0 +1,28 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"go.innotegrity.dev/zerolog/log"
+)
+
+// SetCorrelationID adds a new random correlation ID to the global logger.
+//
+// Every message logged while handling a single OpenVPN request then carries the same correlation_id field so that all
+// of the messages for one attempt can be found together.
+func SetCorrelationID() {
+	log.ReplaceGlobal(log.With().Str("correlation_id", newCorrelationID()).Logger())
+}
+
+// newCorrelationID generates a random correlation ID, falling back to the current time should no random data be
+// available.
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,9 +1,15 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
 	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
@@ -13,30 +19,184 @@ import (
 
 // OpenVPNClientRequest holds data from the OpenVPN connection request
 type OpenVPNClientRequest struct {
+	// AuthFailedReasonFile holds the path to the file in which to write the reason authentication failed.
+	//
+	// This is only supported by OpenVPN 2.5 and later and may be empty.
+	AuthFailedReasonFile string
+
+	// AuthPendingFile holds the path to the file in which to write pending authentication details.
+	//
+	// This is only supported by OpenVPN 2.6 and later and may be empty.
+	AuthPendingFile string
+
+	// ChallengeResponse holds the user's response to a dynamic challenge (CRV1) from a previous request.
+	ChallengeResponse string
+
+	// ChallengeStateID holds the state ID of the dynamic challenge (CRV1) the user is responding to, if any.
+	ChallengeStateID string
+
 	// ClientIP holds the client's untrusted IP address from the authentication request.
 	ClientIP string
 
+	// DeviceToken holds a token which identifies the client device across connections.
+	//
+	// This is empty if OpenVPN did not supply enough information about the client to identify it.
+	DeviceToken string
+
+	// SSOMethods holds the list of pending authentication methods supported by the client (eg: crtext, openurl).
+	SSOMethods []string
+
+	// GUIVersion holds the name and version of the client's user interface (IV_GUI_VER), if supplied.
+	GUIVersion string
+
 	// Location, if present, holds additional information about the location of the client IP.
 	Location string
 
+	// MFAResponse holds the user's response to a static challenge (SCRV1) (eg: a TOTP passcode or 'push').
+	//
+	// This is empty if the client did not use a static challenge.
+	MFAResponse string
+
+	// OriginalUsername holds the username from the authentication request before any username rules were applied.
+	OriginalUsername string
+
 	// Password holds the password from the authentication request.
 	Password string
 
-	// Username holds the username from the authentication request.
+	// Platform holds the client's operating system platform (IV_PLAT) (eg: win, mac, linux), if supplied.
+	Platform string
+
+	// Username holds the username from the authentication request after applying any username rules.
 	Username string
+
+	// Version holds the client's OpenVPN version (IV_VER), if supplied.
+	Version string
 }
 
 // NewOpenVPNClientRequest creates a new OpenVPNClientRequest object based on environment variables.
 func NewOpenVPNClientRequest() *OpenVPNClientRequest {
 	req := &OpenVPNClientRequest{
-		Username: os.Getenv("username"),
-		Password: os.Getenv("password"),
-		ClientIP: os.Getenv("untrusted_ip"),
+		AuthFailedReasonFile: os.Getenv("auth_failed_reason_file"),
+		AuthPendingFile:      os.Getenv("auth_pending_file"),
+		OriginalUsername:     os.Getenv("username"),
+		Password:             os.Getenv("password"),
+		ClientIP:             os.Getenv("untrusted_ip"),
+		GUIVersion:           os.Getenv("IV_GUI_VER"),
+		Platform:             os.Getenv("IV_PLAT"),
+		Version:              os.Getenv("IV_VER"),
+	}
+	req.Username = NormalizeUsername(req.OriginalUsername, app.Config.Auth.UsernameRules)
+	if sso := os.Getenv("IV_SSO"); sso != "" {
+		req.SSOMethods = strings.Split(sso, ",")
 	}
+	req.parseDynamicChallengeResponse()
+	req.parseStaticChallengeResponse()
 	req.Location = getLocation(req.ClientIP)
+	req.DeviceToken = deviceToken(os.Getenv("IV_HWADDR"), req.Platform, os.Getenv("tls_digest_sha256_0"))
 	return req
 }
 
+// DynamicChallenge returns an OpenVPN dynamic challenge (CRV1) which prompts the user for a response.
+//
+// The challenge is sent to the client as the authentication failure reason.  The client then reconnects supplying
+// CRV1::<state_id>::<response> as the password.
+func DynamicChallenge(stateID, username, prompt string, echo bool) string {
+	flags := "R"
+	if echo {
+		flags = "R,E"
+	}
+	return fmt.Sprintf("CRV1:%s:%s:%s:%s", flags, stateID, base64.StdEncoding.EncodeToString([]byte(username)),
+		prompt)
+}
+
+// SupportsSSOMethod determines whether or not the client supports the given pending authentication method.
+func (r *OpenVPNClientRequest) SupportsSSOMethod(method string) bool {
+	for _, m := range r.SSOMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAuthFailedReason writes the reason authentication failed so that OpenVPN can send it to the client.
+//
+// If OpenVPN did not supply a file for the reason, nothing is written.
+func (r *OpenVPNClientRequest) SetAuthFailedReason(reason string) error {
+	if r.AuthFailedReasonFile == "" {
+		return nil
+	}
+	return ioutil.WriteFile(r.AuthFailedReasonFile, []byte(reason), 0600)
+}
+
+// SetAuthPending writes pending authentication details so that OpenVPN can extend the authentication timeout and
+// send the extra information (eg: a CR_TEXT challenge) to the client.
+//
+// If OpenVPN did not supply a file for pending authentication, nothing is written.
+func (r *OpenVPNClientRequest) SetAuthPending(timeout time.Duration, method, extra string) error {
+	if r.AuthPendingFile == "" {
+		return nil
+	}
+	data := fmt.Sprintf("%d\n%s\n%s\n", int(timeout.Seconds()), method, extra)
+	return ioutil.WriteFile(r.AuthPendingFile, []byte(data), 0600)
+}
+
+// parseDynamicChallengeResponse extracts the state ID and response from the password if it holds a response to a
+// dynamic challenge (CRV1::<state_id>::<response>).
+func (r *OpenVPNClientRequest) parseDynamicChallengeResponse() {
+	if !strings.HasPrefix(r.Password, "CRV1::") {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.Password, "CRV1::"), "::", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return
+	}
+	r.ChallengeStateID = parts[0]
+	r.ChallengeResponse = parts[1]
+	r.Password = ""
+}
+
+// parseStaticChallengeResponse decodes the password and MFA response if the password holds a response to a static
+// challenge (SCRV1:<base64 password>:<base64 response>).
+//
+// If the password cannot be decoded, it is left unchanged.
+func (r *OpenVPNClientRequest) parseStaticChallengeResponse() {
+	if !strings.HasPrefix(r.Password, "SCRV1:") {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.Password, "SCRV1:"), ":", 2)
+	if len(parts) != 2 {
+		log.Warn().Str("username", r.Username).Msg("static challenge response is malformed; ignoring it")
+		return
+	}
+	password, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		log.Warn().Err(err).Str("username", r.Username).Msg("failed to decode static challenge password; ignoring it")
+		return
+	}
+	response, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		log.Warn().Err(err).Str("username", r.Username).Msg("failed to decode static challenge response; ignoring it")
+		return
+	}
+	r.Password = string(password)
+	r.MFAResponse = strings.TrimSpace(string(response))
+}
+
+// deviceToken returns a token identifying the client device derived from its hardware address, platform and
+// certificate fingerprint.
+//
+// Okta limits device tokens to 32 characters so only the first half of the SHA-256 digest is used.  An empty string is
+// returned if the certificate fingerprint is not known since the hardware address and platform are supplied by the
+// client and could be replayed by anyone who knows them to skip MFA.
+func deviceToken(hwAddr, platform, certFingerprint string) string {
+	if certFingerprint == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{hwAddr, platform, certFingerprint}, "|")))
+	return hex.EncodeToString(sum[:16])
+}
+
 // getLocation returns the location of the IP address, if known, or "(unknown)" if an error occurs.
 func getLocation(ip string) string {
 	config := app.Config.Auth
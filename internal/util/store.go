@@ -0,0 +1,173 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/errors"
+	"go.innotegrity.dev/zerolog/log"
+)
+
+// stateKeyRegex matches valid state store keys.
+var stateKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// stateEntry is the on-disk representation of a value saved in the state store.
+type stateEntry struct {
+	ExpiresAt time.Time       `json:"expiresAt"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// StateStore persists short-lived state (eg: pending MFA transactions) between invocations of the plugin.
+//
+// Each value is stored as a JSON file in the store's directory and is ignored and removed once it expires.
+type StateStore struct {
+	// unexported variables
+	dir string
+}
+
+// NewStateStore creates a new StateStore object which saves its state in the given directory.
+func NewStateStore(dir string) *StateStore {
+	return &StateStore{
+		dir: dir,
+	}
+}
+
+// Delete removes the value with the given key from the store.
+//
+// The following errors are returned by this function:
+// StateStoreFailure
+func (s *StateStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		e := &errors.StateStoreFailure{
+			Key: key,
+			Err: err,
+		}
+		log.Error().Err(e.InternalError()).Str("state_dir", s.dir).Str("key", e.Key).Msg(e.Error())
+		return e
+	}
+	return nil
+}
+
+// Load reads the value with the given key from the store into the given object.
+//
+// If the value does not exist or has expired, false is returned.
+//
+// The following errors are returned by this function:
+// StateStoreFailure
+func (s *StateStore) Load(key string, value interface{}) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		e := &errors.StateStoreFailure{
+			Key: key,
+			Err: err,
+		}
+		log.Error().Err(e.InternalError()).Str("state_dir", s.dir).Str("key", e.Key).Msg(e.Error())
+		return false, e
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		e := &errors.StateStoreFailure{
+			Key: key,
+			Err: err,
+		}
+		log.Error().Err(e.InternalError()).Str("state_dir", s.dir).Str("key", e.Key).Msg(e.Error())
+		return false, e
+	}
+	var entry stateEntry
+	if err := json.Unmarshal(data, &entry); err == nil && time.Now().Before(entry.ExpiresAt) {
+		if err := json.Unmarshal(entry.Value, value); err == nil {
+			return true, nil
+		}
+	}
+
+	// the entry is expired or corrupt so just get rid of it
+	return false, s.Delete(key)
+}
+
+// Purge removes all expired values from the store.
+func (s *StateStore) Purge() {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		var entry stateEntry
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		if err != nil || time.Now().After(entry.ExpiresAt) {
+			os.Remove(file)
+		}
+	}
+}
+
+// Save writes the value to the store under the given key, replacing any existing value.
+//
+// The value is discarded once the expiration time has passed.
+//
+// The following errors are returned by this function:
+// StateStoreFailure
+func (s *StateStore) Save(key string, value interface{}, expiresAt time.Time) error {
+	path, err := s.path(key)
+	if err == nil {
+		err = os.MkdirAll(s.dir, 0700)
+	}
+	if err == nil {
+		var data []byte
+		if data, err = json.Marshal(value); err == nil {
+			if data, err = json.Marshal(stateEntry{ExpiresAt: expiresAt, Value: data}); err == nil {
+				err = s.writeFile(path, data)
+			}
+		}
+	}
+	if err != nil {
+		e := &errors.StateStoreFailure{
+			Key: key,
+			Err: err,
+		}
+		log.Error().Err(e.InternalError()).Str("state_dir", s.dir).Str("key", e.Key).Msg(e.Error())
+		return e
+	}
+	s.Purge()
+	return nil
+}
+
+// path returns the path to the file which stores the value for the given key.
+func (s *StateStore) path(key string) (string, error) {
+	if !stateKeyRegex.MatchString(key) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("'%s': invalid state key", key)
+	}
+	return filepath.Join(s.dir, fmt.Sprintf("%s.json", key)), nil
+}
+
+// writeFile atomically writes the data to the given file so a concurrent reader never sees a partial value.
+func (s *StateStore) writeFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"strings"
+
+	"github.com/josh-hogle/okta-openvpn-auth-plugin/internal/app"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeUsername applies the given username rules to the username.
+//
+// The rules are applied in the following order: Unicode normalization, domain prefix stripping, lowercasing, rewrite
+// rules and finally the default domain.  Leading and trailing whitespace is removed first, but only if at least one
+// rule is enabled so that the username is left untouched otherwise.
+func NormalizeUsername(username string, rules app.UsernameRulesOptions) string {
+	if !rules.UnicodeNormalize && !rules.StripDomainPrefix && !rules.Lowercase && len(rules.Rewrites) == 0 &&
+		rules.DefaultDomain == "" {
+		return username
+	}
+	username = strings.TrimSpace(username)
+	if rules.UnicodeNormalize {
+		username = norm.NFKC.String(username)
+	}
+	if rules.StripDomainPrefix {
+		if i := strings.LastIndex(username, "\\"); i >= 0 {
+			username = username[i+1:]
+		}
+	}
+	if rules.Lowercase {
+		username = strings.ToLower(username)
+	}
+	for _, rule := range rules.Rewrites {
+		if rule.Regexp != nil {
+			username = rule.Regexp.ReplaceAllString(username, rule.Replacement)
+		}
+	}
+	if rules.DefaultDomain != "" && username != "" && !strings.Contains(username, "@") {
+		username = username + "@" + rules.DefaultDomain
+	}
+	return username
+}